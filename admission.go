@@ -0,0 +1,58 @@
+package dailzCache
+
+import (
+	"github.com/dailz1/dailzCache/countMinSketch"
+	"sync"
+)
+
+// An AdmissionPolicy decides whether a value loaded from a peer is worth
+// a copy in the hot cache. Admit is called once for each such load and
+// must be safe for concurrent use.
+type AdmissionPolicy interface {
+	Admit(key string) bool
+}
+
+const (
+	defaultHotThreshold  = 3
+	defaultHotSampleSize = 10000
+)
+
+// FrequencyAdmission admits a key into the hot cache once it has been
+// loaded from peers threshold times among the last sampleSize or so
+// loads, as estimated by a count-min sketch whose counts are halved
+// after every sampleSize loads. The sketch, of 40 bytes per sampled
+// load, is only made at the first load, so groups that never load from
+// peers do not pay for it.
+type FrequencyAdmission struct {
+	threshold  int
+	sampleSize int
+
+	mu     sync.Mutex
+	sketch *countMinSketch.Sketch // nil until the first Admit
+}
+
+// NewFrequencyAdmission creates a FrequencyAdmission.
+// Zero values select 3 loads among 10000.
+func NewFrequencyAdmission(threshold, sampleSize int) *FrequencyAdmission {
+	if threshold <= 0 {
+		threshold = defaultHotThreshold
+	}
+	if sampleSize <= 0 {
+		sampleSize = defaultHotSampleSize
+	}
+	return &FrequencyAdmission{
+		threshold:  threshold,
+		sampleSize: sampleSize,
+	}
+}
+
+// Admit counts a load of key from a peer and reports whether it is hot.
+func (a *FrequencyAdmission) Admit(key string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.sketch == nil {
+		// 10 counters per sampled load keep collisions rare
+		a.sketch = countMinSketch.New(10*a.sampleSize, 4, a.sampleSize)
+	}
+	return a.sketch.Increment(key) >= a.threshold
+}
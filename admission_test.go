@@ -0,0 +1,64 @@
+package dailzCache
+
+import (
+	"testing"
+)
+
+func TestFrequencyAdmission(t *testing.T) {
+	a := NewFrequencyAdmission(3, 100)
+	for i := 1; i < 3; i++ {
+		if a.Admit("hot") {
+			t.Fatalf("Admit after %d loads = true; want false", i)
+		}
+	}
+	if !a.Admit("hot") {
+		t.Errorf("Admit after 3 loads = false; want true")
+	}
+	if a.Admit("cold") {
+		t.Errorf("Admit(cold) = true; want false")
+	}
+}
+
+func TestHotAdmission(t *testing.T) {
+	peers := &fakePeers{owner: &fakePeer{}}
+	g := NewUniverse().NewGroupOpts("hot-admission", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), &GroupOptions{HotAdmission: NewFrequencyAdmission(2, 100)})
+	g.RegisterPeers(peers)
+
+	for _, key := range []string{"cold", "hot", "hot", "hot"} {
+		if _, err := g.Get(key); err != nil {
+			t.Fatalf("Get(%q) error = %v", key, err)
+		}
+	}
+	if _, ok := g.hotCache.get("cold"); ok {
+		t.Errorf("cold key was copied into the hot cache")
+	}
+	if _, ok := g.hotCache.get("hot"); !ok {
+		t.Errorf("hot key was not copied into the hot cache")
+	}
+	if got := peers.owner.gets; got != 3 {
+		t.Errorf("peer gets = %d; want 3", got)
+	}
+	if got, want := g.Stats.HotAdmits.Get(), int64(1); got != want {
+		t.Errorf("HotAdmits = %d; want %d", got, want)
+	}
+	if got, want := g.Stats.HotRejects.Get(), int64(2); got != want {
+		t.Errorf("HotRejects = %d; want %d", got, want)
+	}
+}
+
+func TestFrequencyAdmissionSketchOnFirstLoad(t *testing.T) {
+	g := NewUniverse().NewGroup("admission-no-peers", 1<<10, countingGetter(new(AtomicInt)))
+	if _, err := g.Get("key"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	a := g.opts.HotAdmission.(*FrequencyAdmission)
+	if a.sketch != nil {
+		t.Errorf("a group without peers made an admission sketch")
+	}
+	a.Admit("key")
+	if a.sketch == nil {
+		t.Errorf("Admit did not make the sketch")
+	}
+}
@@ -1,41 +1,355 @@
-package main
+package dailzCache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// chunkSize is the largest piece a value is stored and sent in.
+// Larger values are split into chunks, see makeView.
+const chunkSize = 256 << 10
 
 // A ByteView holds an immutable view of bytes.
-// Internally it wraps either a []byte or a string,
-// but that detail is invisible to callers.
+// Internally it wraps either a []byte, a string or, for large values,
+// a list of chunks, but that detail is invisible to callers.
 //
 // A ByteView is meant to be used as a value type, not
 // a pointer (like a time.Time).
+//
+// The bytes a view wraps are never modified once the view is made,
+// so views and their slices share them without copying. Only the
+// accessors that hand out a []byte to callers make a copy.
 type ByteView struct {
 	// // If data is non-nil, data is used, else str is used.
 	data []byte
 	str  string
+	// chunks holds a large value in pieces. If non-nil, it is used
+	// instead of data and str.
+	chunks [][]byte
+	// e is the time the value expires, the zero time means never.
+	e time.Time
+	// notFound marks a negative entry, caching that the key does not exist.
+	notFound bool
+	// cost is how long the value took to load, see GreedyDualSizePolicy.
+	cost time.Duration
+}
+
+// makeView returns a view of a copy of b, split into chunks if
+// it is larger than chunkSize.
+func makeView(b []byte) ByteView {
+	if len(b) <= chunkSize {
+		return ByteView{data: cloneBytes(b)}
+	}
+	v := viewOf(b)
+	for i, c := range v.chunks {
+		v.chunks[i] = cloneBytes(c)
+	}
+	return v
+}
+
+// viewOf is like makeView but wraps b itself, which must not be
+// modified afterwards.
+func viewOf(b []byte) ByteView {
+	if len(b) <= chunkSize {
+		return ByteView{data: b}
+	}
+	chunks := make([][]byte, 0, (len(b)+chunkSize-1)/chunkSize)
+	for len(b) > 0 {
+		n := len(b)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		chunks = append(chunks, b[:n:n])
+		b = b[n:]
+	}
+	return ByteView{chunks: chunks}
 }
 
 // Len returns the view's length.
 func (v ByteView) Len() int {
+	if v.chunks != nil {
+		n := 0
+		for _, c := range v.chunks {
+			n += len(c)
+		}
+		return n
+	}
 	if v.data != nil {
 		return len(v.data)
 	}
 	return len(v.str)
 }
 
+// Expire returns the time the view expires,
+// or the zero time if it never does.
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+// expired reports whether the view has expired at now.
+func (v ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && !now.Before(v.e)
+}
+
 // ByteSlice returns a copy of the data as a byte slice.
 func (v ByteView) ByteSlice() []byte {
+	if v.chunks != nil {
+		return bytes.Join(v.chunks, nil)
+	}
 	if v.data != nil {
 		return cloneBytes(v.data)
 	}
 	return []byte(v.str)
 }
 
+// rawBytes returns the data as a byte slice, without copying it when the
+// view wraps a []byte. The result is shared and must not be modified.
+func (v ByteView) rawBytes() []byte {
+	if v.chunks != nil {
+		return bytes.Join(v.chunks, nil)
+	}
+	if v.data != nil {
+		return v.data
+	}
+	return []byte(v.str)
+}
+
 // String returns the data as a string, making a copy if necessary.
 func (v ByteView) String() string {
+	if v.chunks != nil {
+		return string(bytes.Join(v.chunks, nil))
+	}
 	if v.data != nil {
 		return string(v.data)
 	}
 	return v.str
 }
 
+// At returns the byte at index i.
+func (v ByteView) At(i int) byte {
+	if v.chunks != nil {
+		for _, c := range v.chunks {
+			if i < len(c) {
+				return c[i]
+			}
+			i -= len(c)
+		}
+		panic("view: index out of range")
+	}
+	if v.data != nil {
+		return v.data[i]
+	}
+	return v.str[i]
+}
+
+// Slice slices the view between the provided from and to indices.
+func (v ByteView) Slice(from, to int) ByteView {
+	if v.chunks != nil {
+		return sliceChunks(v.chunks, from, to)
+	}
+	if v.data != nil {
+		return ByteView{data: v.data[from:to]}
+	}
+	return ByteView{str: v.str[from:to]}
+}
+
+// SliceFrom slices the view from the provided index until the end.
+func (v ByteView) SliceFrom(from int) ByteView {
+	if v.chunks != nil {
+		return sliceChunks(v.chunks, from, v.Len())
+	}
+	if v.data != nil {
+		return ByteView{data: v.data[from:]}
+	}
+	return ByteView{str: v.str[from:]}
+}
+
+// sliceChunks returns the view of chunks between from and to, sharing
+// the chunks' memory.
+func sliceChunks(chunks [][]byte, from, to int) ByteView {
+	if from < 0 || from > to {
+		panic("view: slice bounds out of range")
+	}
+	var out [][]byte
+	for _, c := range chunks {
+		if to <= 0 {
+			break
+		}
+		if from < len(c) {
+			end := len(c)
+			if to < end {
+				end = to
+			}
+			out = append(out, c[from:end])
+		}
+		from -= len(c)
+		if from < 0 {
+			from = 0
+		}
+		to -= len(c)
+	}
+	if to > 0 {
+		panic("view: slice bounds out of range")
+	}
+	switch len(out) {
+	case 0:
+		return ByteView{data: []byte{}}
+	case 1:
+		return ByteView{data: out[0]}
+	}
+	return ByteView{chunks: out}
+}
+
+// Copy copies the view into dest and returns the number of bytes copied.
+func (v ByteView) Copy(dest []byte) int {
+	if v.chunks != nil {
+		n := 0
+		for _, c := range v.chunks {
+			n += copy(dest[n:], c)
+		}
+		return n
+	}
+	if v.data != nil {
+		return copy(dest, v.data)
+	}
+	return copy(dest, v.str)
+}
+
+// Equal returns whether the bytes in v are the same as the bytes in v2.
+func (v ByteView) Equal(v2 ByteView) bool {
+	if v2.chunks != nil {
+		if v.Len() != v2.Len() {
+			return false
+		}
+		off := 0
+		for _, c := range v2.chunks {
+			if !v.Slice(off, off+len(c)).EqualBytes(c) {
+				return false
+			}
+			off += len(c)
+		}
+		return true
+	}
+	if v2.data == nil {
+		return v.EqualString(v2.str)
+	}
+	return v.EqualBytes(v2.data)
+}
+
+// EqualString returns whether the bytes in v are the same as the bytes in s.
+func (v ByteView) EqualString(s string) bool {
+	if v.chunks != nil {
+		if v.Len() != len(s) {
+			return false
+		}
+		for _, c := range v.chunks {
+			if string(c) != s[:len(c)] {
+				return false
+			}
+			s = s[len(c):]
+		}
+		return true
+	}
+	if v.data == nil {
+		return v.str == s
+	}
+	return string(v.data) == s
+}
+
+// EqualBytes returns whether the bytes in v are the same as the bytes in b2.
+func (v ByteView) EqualBytes(b2 []byte) bool {
+	if v.chunks != nil {
+		if v.Len() != len(b2) {
+			return false
+		}
+		for _, c := range v.chunks {
+			if !bytes.Equal(c, b2[:len(c)]) {
+				return false
+			}
+			b2 = b2[len(c):]
+		}
+		return true
+	}
+	if v.data != nil {
+		return bytes.Equal(v.data, b2)
+	}
+	return v.str == string(b2)
+}
+
+// Reader returns an io.ReadSeeker for the bytes in v.
+// It reads large values chunk by chunk, without joining them.
+func (v ByteView) Reader() io.ReadSeeker {
+	if v.chunks != nil {
+		return io.NewSectionReader(v, 0, int64(v.Len()))
+	}
+	if v.data != nil {
+		return bytes.NewReader(v.data)
+	}
+	return strings.NewReader(v.str)
+}
+
+// ReadAt implements io.ReaderAt on the bytes in v.
+func (v ByteView) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("view: invalid offset")
+	}
+	if off >= int64(v.Len()) {
+		return 0, io.EOF
+	}
+	n = v.SliceFrom(int(off)).Copy(p)
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+// WriteTo implements io.WriterTo on the bytes in v.
+func (v ByteView) WriteTo(w io.Writer) (n int64, err error) {
+	var m int
+	if v.chunks != nil {
+		err = v.forEachChunk(func(c []byte) error {
+			k, err := w.Write(c)
+			m += k
+			return err
+		})
+	} else if v.data != nil {
+		m, err = w.Write(v.data)
+	} else {
+		m, err = io.WriteString(w, v.str)
+	}
+	if err == nil && m < v.Len() {
+		err = io.ErrShortWrite
+	}
+	n = int64(m)
+	return
+}
+
+// forEachChunk calls fn with the bytes in v in order, in pieces of at
+// most chunkSize bytes, and stops at the first error.
+func (v ByteView) forEachChunk(fn func(c []byte) error) error {
+	if v.chunks != nil {
+		for _, c := range v.chunks {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for off := 0; off < v.Len(); off += chunkSize {
+		end := off + chunkSize
+		if end > v.Len() {
+			end = v.Len()
+		}
+		if err := fn(v.Slice(off, end).rawBytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func cloneBytes(data []byte) []byte {
 	c := make([]byte, len(data))
 	copy(c, data)
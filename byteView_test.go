@@ -1 +1,205 @@
-package main
+package dailzCache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// of returns a byte view of the []byte or string in x.
+func of(x interface{}) ByteView {
+	if bytes, ok := x.([]byte); ok {
+		return ByteView{data: bytes}
+	}
+	return ByteView{str: x.(string)}
+}
+
+func TestByteView(t *testing.T) {
+	for _, s := range []string{"", "x", "yy"} {
+		for _, v := range []ByteView{of([]byte(s)), of(s)} {
+			name := fmt.Sprintf("string %q, view %+v", s, v)
+			if v.Len() != len(s) {
+				t.Errorf("%s: Len = %d; want %d", name, v.Len(), len(s))
+			}
+			if v.String() != s {
+				t.Errorf("%s: String = %q; want %q", name, v.String(), s)
+			}
+			var longDest [3]byte
+			if n := v.Copy(longDest[:]); n != len(s) {
+				t.Errorf("%s: long Copy = %d; want %d", name, n, len(s))
+			}
+			var shortDest [1]byte
+			if n := v.Copy(shortDest[:]); n != min(len(s), 1) {
+				t.Errorf("%s: short Copy = %d; want %d", name, n, min(len(s), 1))
+			}
+			if got, err := io.ReadAll(v.Reader()); err != nil || string(got) != s {
+				t.Errorf("%s: Reader = %q, %v; want %q", name, got, err, s)
+			}
+			if got, err := io.ReadAll(io.NewSectionReader(v, 0, int64(len(s)))); err != nil || string(got) != s {
+				t.Errorf("%s: SectionReader of ReaderAt = %q, %v; want %q", name, got, err, s)
+			}
+			var dest bytes.Buffer
+			if _, err := v.WriteTo(&dest); err != nil || !bytes.Equal(dest.Bytes(), []byte(s)) {
+				t.Errorf("%s: WriteTo = %q, %v; want %q", name, dest.Bytes(), err, s)
+			}
+		}
+	}
+}
+
+func TestByteViewEqual(t *testing.T) {
+	tests := []struct {
+		a    interface{} // string or []byte
+		b    interface{} // string or []byte
+		want bool
+	}{
+		{"x", "x", true},
+		{"x", "y", false},
+		{"x", "yy", false},
+		{[]byte("x"), []byte("x"), true},
+		{[]byte("x"), []byte("y"), false},
+		{[]byte("x"), []byte("yy"), false},
+		{[]byte("x"), "x", true},
+		{[]byte("x"), "y", false},
+		{[]byte("x"), "yy", false},
+		{"x", []byte("x"), true},
+		{"x", []byte("y"), false},
+		{"x", []byte("yy"), false},
+	}
+	for i, tt := range tests {
+		va := of(tt.a)
+		if bytes, ok := tt.b.([]byte); ok {
+			if got := va.EqualBytes(bytes); got != tt.want {
+				t.Errorf("%d. EqualBytes = %v; want %v", i, got, tt.want)
+			}
+		} else {
+			if got := va.EqualString(tt.b.(string)); got != tt.want {
+				t.Errorf("%d. EqualString = %v; want %v", i, got, tt.want)
+			}
+		}
+		if got := va.Equal(of(tt.b)); got != tt.want {
+			t.Errorf("%d. Equal = %v; want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestByteViewSlice(t *testing.T) {
+	tests := []struct {
+		in   string
+		from int
+		to   interface{} // nil to mean the end (SliceFrom); else int
+		want string
+	}{
+		{in: "abc", from: 1, to: 2, want: "b"},
+		{in: "abc", from: 1, want: "bc"},
+		{in: "abc", to: 2, want: "ab"},
+	}
+	for i, tt := range tests {
+		for _, v := range []ByteView{of([]byte(tt.in)), of(tt.in)} {
+			name := fmt.Sprintf("test %d, view %+v", i, v)
+			if tt.to != nil {
+				v = v.Slice(tt.from, tt.to.(int))
+			} else {
+				v = v.SliceFrom(tt.from)
+			}
+			if v.String() != tt.want {
+				t.Errorf("%s: got %q; want %q", name, v.String(), tt.want)
+			}
+			if v.At(0) != tt.want[0] {
+				t.Errorf("%s: At(0) = %q; want %q", name, v.At(0), tt.want[0])
+			}
+		}
+	}
+}
+
+func TestByteViewReadAt(t *testing.T) {
+	v := of("abc")
+	p := make([]byte, 2)
+	if n, err := v.ReadAt(p, 2); n != 1 || err != io.EOF || p[0] != 'c' {
+		t.Errorf("ReadAt(2) = %d, %v, %q; want 1, EOF, c", n, err, p[:n])
+	}
+	if _, err := v.ReadAt(p, 3); err != io.EOF {
+		t.Errorf("ReadAt(3) error = %v; want EOF", err)
+	}
+	if _, err := v.ReadAt(p, -1); err == nil {
+		t.Errorf("ReadAt(-1) error = nil; want an error")
+	}
+}
+
+func TestByteViewChunks(t *testing.T) {
+	want := make([]byte, 3*chunkSize+10)
+	for i := range want {
+		want[i] = byte(i % 251)
+	}
+	v := makeView(want)
+	if len(v.chunks) != 4 {
+		t.Fatalf("makeView made %d chunks; want 4", len(v.chunks))
+	}
+	if v.Len() != len(want) || !v.EqualBytes(want) || !v.EqualString(string(want)) {
+		t.Errorf("chunked view does not hold the %d bytes it was made of", len(want))
+	}
+	if !v.Equal(of(want)) || !of(want).Equal(v) || !v.Equal(v) {
+		t.Errorf("chunked view not Equal to the same bytes")
+	}
+	if v.Equal(of(want[1:])) || v.EqualBytes(append(want[:len(want)-1:len(want)-1], 0xff)) {
+		t.Errorf("chunked view Equal to different bytes")
+	}
+	if got := v.ByteSlice(); !bytes.Equal(got, want) {
+		t.Errorf("ByteSlice differs from the bytes of the view")
+	}
+	if got := v.At(2*chunkSize + 1); got != want[2*chunkSize+1] {
+		t.Errorf("At = %d; want %d", got, want[2*chunkSize+1])
+	}
+
+	// slices across chunk boundaries share the chunks
+	for _, r := range [][2]int{{0, 0}, {5, 10}, {chunkSize - 1, chunkSize + 1}, {10, 3 * chunkSize}, {0, len(want)}} {
+		if got := v.Slice(r[0], r[1]); !got.EqualBytes(want[r[0]:r[1]]) {
+			t.Errorf("Slice(%d, %d) = %d bytes; want %d", r[0], r[1], got.Len(), r[1]-r[0])
+		}
+	}
+	if got := v.SliceFrom(chunkSize + 3); !got.EqualBytes(want[chunkSize+3:]) {
+		t.Errorf("SliceFrom differs from the bytes of the view")
+	}
+
+	dest := make([]byte, len(want))
+	if n := v.Copy(dest); n != len(want) || !bytes.Equal(dest, want) {
+		t.Errorf("Copy = %d; want %d", n, len(want))
+	}
+	if got, err := io.ReadAll(v.Reader()); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Reader = %d bytes, %v; want %d bytes", len(got), err, len(want))
+	}
+	r := v.Reader()
+	if _, err := r.Seek(chunkSize-2, io.SeekStart); err != nil {
+		t.Fatalf("Seek error = %v", err)
+	}
+	part := make([]byte, 4)
+	if _, err := io.ReadFull(r, part); err != nil || !bytes.Equal(part, want[chunkSize-2:chunkSize+2]) {
+		t.Errorf("Read after Seek = %v, %v; want %v", part, err, want[chunkSize-2:chunkSize+2])
+	}
+	var buf bytes.Buffer
+	if n, err := v.WriteTo(&buf); err != nil || n != int64(len(want)) || !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("WriteTo = %d, %v; want %d", n, err, len(want))
+	}
+
+	// the view holds a copy
+	want[0]++
+	if v.At(0) == want[0] {
+		t.Errorf("makeView shares memory with its argument")
+	}
+
+	// viewOf splits the same way without copying
+	w := viewOf(want)
+	if len(w.chunks) != 4 || !w.EqualBytes(want) {
+		t.Errorf("viewOf made %d chunks; want 4 holding the bytes", len(w.chunks))
+	}
+	if &w.chunks[0][0] != &want[0] {
+		t.Errorf("viewOf copied its argument")
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -1,18 +1,35 @@
-package main
+package dailzCache
 
 import (
-	"dailzCache/lru"
+	"container/heap"
 	"sync"
+	"time"
 )
 
 type cache struct {
-	mu         sync.Mutex
-	usedBytes  int64 // number of all keys and values
-	lru        *lru.Cache
-	maxEntries int
-	hitNum     int64
-	getNum     int64
-	evictNum   int64 // number of evictions
+	mu        sync.Mutex
+	usedBytes int64 // number of all keys and values
+	// newPolicy creates policy on first use, nil means LRUPolicy.
+	newPolicy PolicyFunc
+	policy    EvictionPolicy
+	hitNum    int64
+	getNum    int64
+	evictNum  int64 // number of evictions
+	negBytes  int64 // bytes of negative entries, included in usedBytes
+	negItems  int64 // number of negative entries
+	// expiries holds when the entries with an expiry expire, and
+	// expiryOf the element of expiries for each of these keys, so that
+	// the heap has one element per entry however often it is replaced.
+	expiries expiryHeap
+	expiryOf map[string]*expiry
+	// stale is how long an expired entry is still kept and returned,
+	// see GroupOptions.StaleWhileRevalidate.
+	stale time.Duration
+	// removing is set while an entry is removed on purpose, so that
+	// onEvicted does not count it as an eviction.
+	removing bool
+	// closed is set by close, a closed cache drops every add.
+	closed bool
 }
 
 // CacheStats are returned by stats accessors on Group.
@@ -22,6 +39,10 @@ type CacheStats struct {
 	Gets      int64
 	Hits      int64
 	Evictions int64
+	// NegativeBytes and NegativeItems account for the entries caching
+	// that a key does not exist. They are included in Bytes and Items.
+	NegativeBytes int64
+	NegativeItems int64
 }
 
 func (c *cache) stats() CacheStats {
@@ -33,46 +54,147 @@ func (c *cache) stats() CacheStats {
 		Gets:      c.getNum,
 		Hits:      c.hitNum,
 		Evictions: c.evictNum,
+
+		NegativeBytes: c.negBytes,
+		NegativeItems: c.negItems,
 	}
 }
 
 func (c *cache) add(key string, value ByteView) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.lru == nil {
-		c.lru = lru.New(c.maxEntries, func(key lru.Key, value lru.Value) {
-			val := value.(ByteView)
-			c.usedBytes -= int64(len(key.(string))) + int64(val.Len())
+	if c.closed {
+		return
+	}
+	if c.policy == nil {
+		if c.newPolicy == nil {
+			c.newPolicy = LRUPolicy()
+		}
+		c.policy = c.newPolicy(func(key string, value ByteView) {
+			n := int64(len(key)) + int64(value.Len())
+			c.usedBytes -= n
+			if !c.removing {
+				c.evictNum++
+			}
+			if value.notFound {
+				c.negBytes -= n
+				c.negItems--
+			}
+			c.setExpiry(key, time.Time{})
 		})
 	}
-	c.lru.Add(key, value)
-	c.usedBytes += int64(len(key)) + int64(value.Len())
+	// 覆盖已有 key 时 policy 不会回调 onEvicted，先减去旧值的字节数；
+	// 旧值不能先 Remove，否则 2Q 的 ghost 记录和 LRU-K 的访问次数会被清掉
+	if old, ok := c.policy.Peek(key); ok {
+		n := int64(len(key)) + int64(old.Len())
+		c.usedBytes -= n
+		if old.notFound {
+			c.negBytes -= n
+			c.negItems--
+		}
+	}
+	c.policy.Add(key, value)
+	n := int64(len(key)) + int64(value.Len())
+	c.usedBytes += n
+	if value.notFound {
+		c.negBytes += n
+		c.negItems++
+	}
+	if _, ok := c.policy.Peek(key); ok {
+		// policy 可能在 Add 时就淘汰了新值
+		c.setExpiry(key, value.e)
+	}
+}
+
+// setExpiry records that the entry for key expires at e, or never if e
+// is zero, replacing what was recorded before.
+func (c *cache) setExpiry(key string, e time.Time) {
+	exp, ok := c.expiryOf[key]
+	switch {
+	case ok && e.IsZero():
+		heap.Remove(&c.expiries, exp.index)
+		delete(c.expiryOf, key)
+	case ok:
+		exp.e = e
+		heap.Fix(&c.expiries, exp.index)
+	case !e.IsZero():
+		if c.expiryOf == nil {
+			c.expiryOf = make(map[string]*expiry)
+		}
+		exp = &expiry{key: key, e: e}
+		c.expiryOf[key] = exp
+		heap.Push(&c.expiries, exp)
+	}
 }
 
 func (c *cache) get(key string) (value ByteView, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.getNum++
-	if c.lru == nil {
+	if c.policy == nil {
 		return
 	}
 
-	if value, ok := c.lru.Get(key); ok {
+	if view, ok := c.policy.Get(key); ok {
+		if view.expired(time.Now().Add(-c.stale)) {
+			// an expired entry is a miss, drop it while we are here
+			c.removeLocked(key)
+			return ByteView{}, false
+		}
 		c.hitNum++
-		return value.(ByteView), ok
+		return view, ok
 	}
 
 	return
 }
 
+// removeExpired removes every entry that has expired at now,
+// past the time it may be returned stale.
+func (c *cache) removeExpired(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now = now.Add(-c.stale)
+	for len(c.expiries) > 0 && !now.Before(c.expiries[0].e) {
+		key := c.expiries[0].key
+		// onEvicted 会把 key 从 expiries 中删除，这里先删除以保证循环结束
+		c.setExpiry(key, time.Time{})
+		c.removeLocked(key)
+	}
+}
+
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.policy != nil {
+		c.removeLocked(key)
+	}
+}
+
+// removeLocked removes key without counting it as an eviction.
+func (c *cache) removeLocked(key string) {
+	c.removing = true
+	c.policy.Remove(key)
+	c.removing = false
+}
+
 func (c *cache) removeOldest() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.lru != nil {
-		c.lru.RemoveOldest()
+	if c.policy != nil {
+		c.policy.RemoveOldest()
 	}
 }
 
+// close frees every entry and makes the cache drop later adds.
+func (c *cache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.policy = nil
+	c.expiries, c.expiryOf = nil, nil
+	c.usedBytes, c.negBytes, c.negItems = 0, 0, 0
+}
+
 func (c *cache) bytes() int64 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -80,8 +202,139 @@ func (c *cache) bytes() int64 {
 }
 
 func (c *cache) itemsLocked() int64 {
-	if c.lru == nil {
+	if c.policy == nil {
 		return 0
 	}
-	return int64(c.lru.Len())
+	return int64(c.policy.Len())
+}
+
+// expiry records when the entry for key expires.
+type expiry struct {
+	key   string
+	e     time.Time
+	index int // index in the expiryHeap
+}
+
+// expiryHeap is a min-heap of expiries, the soonest first.
+type expiryHeap []*expiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].e.Before(h[j].e) }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	exp := x.(*expiry)
+	exp.index = len(*h)
+	*h = append(*h, exp)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+// shardedCache splits a cache into shards by key hash. Each shard has
+// its own lock, so that gets of different keys don't contend. The
+// shards share one budget: removeOldest evicts from the largest shard.
+type shardedCache struct {
+	shards []cache
+}
+
+// init creates n shards using newPolicy, see cache.stale for stale.
+func (c *shardedCache) init(n int, stale time.Duration, newPolicy PolicyFunc) {
+	if n <= 0 {
+		n = 1
+	}
+	c.shards = make([]cache, n)
+	for i := range c.shards {
+		c.shards[i].stale = stale
+		c.shards[i].newPolicy = newPolicy
+	}
+}
+
+// shard returns the shard of key.
+func (c *shardedCache) shard(key string) *cache {
+	if len(c.shards) == 1 {
+		return &c.shards[0]
+	}
+	return &c.shards[fnv32a(key)%uint32(len(c.shards))]
+}
+
+// fnv32a hashes key with 32-bit FNV-1a.
+func fnv32a(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+func (c *shardedCache) add(key string, value ByteView) {
+	c.shard(key).add(key, value)
+}
+
+func (c *shardedCache) get(key string) (value ByteView, ok bool) {
+	return c.shard(key).get(key)
+}
+
+func (c *shardedCache) remove(key string) {
+	c.shard(key).remove(key)
+}
+
+func (c *shardedCache) removeExpired(now time.Time) {
+	for i := range c.shards {
+		c.shards[i].removeExpired(now)
+	}
+}
+
+// removeOldest evicts the oldest entry of the shard using the most bytes.
+func (c *shardedCache) removeOldest() {
+	var victim *cache
+	var most int64
+	for i := range c.shards {
+		if n := c.shards[i].bytes(); victim == nil || n > most {
+			victim, most = &c.shards[i], n
+		}
+	}
+	if victim != nil {
+		victim.removeOldest()
+	}
+}
+
+func (c *shardedCache) bytes() int64 {
+	var n int64
+	for i := range c.shards {
+		n += c.shards[i].bytes()
+	}
+	return n
+}
+
+func (c *shardedCache) stats() CacheStats {
+	var s CacheStats
+	for i := range c.shards {
+		shard := c.shards[i].stats()
+		s.Bytes += shard.Bytes
+		s.Items += shard.Items
+		s.Gets += shard.Gets
+		s.Hits += shard.Hits
+		s.Evictions += shard.Evictions
+		s.NegativeBytes += shard.NegativeBytes
+		s.NegativeItems += shard.NegativeItems
+	}
+	return s
+}
+
+func (c *shardedCache) close() {
+	for i := range c.shards {
+		c.shards[i].close()
+	}
 }
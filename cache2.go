@@ -1 +1 @@
-package main
+package dailzCache
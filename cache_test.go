@@ -0,0 +1,130 @@
+package dailzCache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardedCacheBudget(t *testing.T) {
+	entry := int64(len("key00") + len("value"))
+	g := NewUniverse().NewGroupOpts("sharded", 10*entry, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value"), nil
+	}), &GroupOptions{CacheShards: 4})
+
+	for i := 0; i < 100; i++ {
+		if _, err := g.Get(fmt.Sprintf("key%02d", i)); err != nil {
+			t.Fatalf("Get error = %v", err)
+		}
+	}
+	stats := g.CacheStats(MainCache)
+	if stats.Bytes > 10*entry {
+		t.Errorf("Bytes = %d; want at most the budget, %d", stats.Bytes, 10*entry)
+	}
+	if stats.Items != 10 {
+		t.Errorf("Items = %d; want 10", stats.Items)
+	}
+	if stats.Evictions != 90 {
+		t.Errorf("Evictions = %d; want 90", stats.Evictions)
+	}
+	// the most recent key survives whichever shard it is in
+	if _, ok := g.mainCache.get("key99"); !ok {
+		t.Errorf("most recent key was evicted")
+	}
+}
+
+func TestShardedCacheSpreadsKeys(t *testing.T) {
+	var c shardedCache
+	c.init(8, 0, nil)
+	for i := 0; i < 1000; i++ {
+		c.add(fmt.Sprint(i), ByteView{str: "v"})
+	}
+	for i := range c.shards {
+		if n := c.shards[i].stats().Items; n < 60 || n > 190 {
+			t.Errorf("shard %d holds %d of 1000 keys", i, n)
+		}
+	}
+}
+
+// TestCacheKeepsPolicyHistory checks that refilling a key through cache
+// counts as an access, as it does when the policy is used directly.
+func TestCacheKeepsPolicyHistory(t *testing.T) {
+	// 2Q: a key evicted from recent comes back as frequent,
+	// and outlives the keys added once
+	c := cache{newPolicy: TwoQueuePolicy(0.25, 0)}
+	c.add("a", ByteView{str: "1"})
+	c.removeOldest()
+	c.add("a", ByteView{str: "2"})
+	c.add("b", ByteView{str: "1"})
+	c.add("c", ByteView{str: "1"})
+	c.removeOldest()
+	if _, ok := c.get("a"); !ok {
+		t.Errorf("2Q evicted a ghost key refilled through cache")
+	}
+	if _, ok := c.get("b"); ok {
+		t.Errorf("2Q kept b, added once, over the frequent a")
+	}
+
+	// LRU-K: overwriting a key counts as a reference
+	c = cache{newPolicy: LRUKPolicy(2, 0)}
+	c.add("a", ByteView{str: "1"})
+	c.add("a", ByteView{str: "2"})
+	c.add("b", ByteView{str: "1"})
+	c.removeOldest()
+	if _, ok := c.get("a"); !ok {
+		t.Errorf("LRU-K evicted a key referenced twice through cache")
+	}
+	if got, want := c.bytes(), int64(len("a2")); got != want {
+		t.Errorf("bytes = %d; want %d", got, want)
+	}
+}
+
+func TestCacheStatsEvictions(t *testing.T) {
+	g := NewUniverse().NewGroup("cache-stats", 4*int64(len("k0")+len("value")), GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("value"), nil
+		}))
+	for i := 0; i < 6; i++ {
+		if _, err := g.Get(fmt.Sprintf("k%d", i)); err != nil {
+			t.Fatalf("Get error = %v", err)
+		}
+	}
+	if err := g.Remove("k5"); err != nil {
+		t.Fatalf("Remove error = %v", err)
+	}
+
+	stats := g.CacheStats(MainCache)
+	if stats.Evictions != 2 {
+		t.Errorf("Evictions = %d; want 2, not counting Remove", stats.Evictions)
+	}
+	if stats.Items != 3 {
+		t.Errorf("Items = %d; want 3", stats.Items)
+	}
+	if got := g.CacheStats(HotCache); got.Items != 0 || got.Evictions != 0 {
+		t.Errorf("HotCache stats = %+v; want no items", got)
+	}
+}
+
+// Run with -cpu 1,2,4,8 to see how throughput scales with GOMAXPROCS.
+func BenchmarkGetParallel1Shard(b *testing.B)   { benchmarkGetParallel(b, 1) }
+func BenchmarkGetParallel16Shards(b *testing.B) { benchmarkGetParallel(b, 16) }
+
+func benchmarkGetParallel(b *testing.B, shards int) {
+	const keys = 1024
+	g := NewUniverse().NewGroupOpts("bench", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), &GroupOptions{CacheShards: shards})
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = fmt.Sprint("key", i)
+		g.Get(names[i])
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, err := g.Get(names[i%keys]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
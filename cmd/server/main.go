@@ -2,16 +2,17 @@ package main
 
 import (
 	"flag"
-	"fmt"
+	"github.com/dailz1/dailzCache"
 	"log"
 	"net/http"
 	"strconv"
 	"sync"
+	"time"
 )
 
 var (
 	once  sync.Once
-	group *Group
+	group *dailzCache.Group
 
 	//stringc = make(chan string)
 
@@ -42,43 +43,47 @@ func createDB() {
 }
 
 func createGroup() {
-	group = NewGroup(stringGroupName, cacheSize, GetterFunc(
+	group = dailzCache.NewGroupOpts(stringGroupName, cacheSize, dailzCache.GetterFunc(
 		func(key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			if value, ok := db[key]; ok {
 				return []byte(value), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+			return nil, &dailzCache.NotFoundError{Key: key}
+		}), &dailzCache.GroupOptions{NegativeTTL: 10 * time.Second})
 
 }
 
-func startCacheServer(addr string, addrs []string, group *Group) {
-	/*opts := &HTTPPoolOptions{
+func startCacheServer(addr string, addrs []string, group *dailzCache.Group) {
+	/*opts := &dailzCache.HTTPPoolOptions{
 		BasePath: stringGroupName,
 		Replicas: 0,
 		HashFn:   nil,
 	}*/
-	peers := NewHTTPPool(addr)
+	peers := dailzCache.NewHTTPPool(addr)
 	peers.Set(addrs...)
 	group.RegisterPeers(peers)
 	log.Println("dailzCache is running at", addr)
 	log.Fatal(http.ListenAndServe(addr[7:], peers))
 }
 
-func startAPIServer(apiAddr string, group *Group) {
+func startAPIServer(apiAddr string, group *dailzCache.Group) {
 	http.Handle("/api", http.HandlerFunc(
 		func(writer http.ResponseWriter, request *http.Request) {
 			//log.Println(request.URL)
 			key := request.URL.Query().Get("key")
 			//log.Println(key)
-			view, err := group.Get(key)
+			view, err := group.GetContext(request.Context(), key)
+			if dailzCache.IsNotFound(err) {
+				http.Error(writer, err.Error(), http.StatusNotFound)
+				return
+			}
 			if err != nil {
 				http.Error(writer, err.Error(), http.StatusInternalServerError)
 				return
 			}
 			writer.Header().Set("Content-Type", "application/octet-stream")
-			writer.Write(view.ByteSlice())
+			view.WriteTo(writer)
 		}))
 	log.Println("fontend server is running at", apiAddr)
 	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
@@ -34,7 +34,8 @@ func (m *Map) IsEmpty() bool {
 	return len(m.keys) == 0
 }
 
-// Add adds some keys to the hash.
+// Add adds some keys to the hash. Adding a key already in the hash
+// does nothing.
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
 		// 对每一个真实节点 key，对应地创建 m.replicas 个虚拟节点
@@ -43,6 +44,9 @@ func (m *Map) Add(keys ...string) {
 		// 最后在 hashMap 中添加虚拟节点和真实节点的映射关系
 		for i := 0; i < m.replicas; i++ {
 			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+			if m.hashMap[hash] == key {
+				continue // 重复添加的节点不再创建虚拟节点
+			}
 			m.keys = append(m.keys, hash)
 			m.hashMap[hash] = key
 		}
@@ -51,6 +55,25 @@ func (m *Map) Add(keys ...string) {
 	sort.Ints(m.keys)
 }
 
+// Remove removes a key and its replicas from the hash. Only the items
+// of the removed key move, to the keys that follow it on the ring.
+func (m *Map) Remove(key string) {
+	for i := 0; i < m.replicas; i++ {
+		hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+		if m.hashMap[hash] == key {
+			delete(m.hashMap, hash)
+		}
+	}
+	// 从环上去掉已经没有映射的虚拟节点，剩下的哈希值仍然有序
+	keys := m.keys[:0]
+	for _, hash := range m.keys {
+		if _, ok := m.hashMap[hash]; ok {
+			keys = append(keys, hash)
+		}
+	}
+	m.keys = keys
+}
+
 // Get gets the closest item in the hash to the provided key.
 func (m *Map) Get(key string) string {
 	if m.IsEmpty() {
@@ -72,3 +95,28 @@ func (m *Map) Get(key string) string {
 	// 从 m.keys 中获取到对应的哈希值，然后通过 m.hashMap 映射得到真实的节点
 	return m.hashMap[m.keys[index]]
 }
+
+// GetN gets up to n distinct items for the key, in the order they follow
+// the key on the ring. The first one is the item Get returns.
+func (m *Map) GetN(key string, n int) []string {
+	if m.IsEmpty() || n <= 0 {
+		return nil
+	}
+
+	hash := int(m.hash([]byte(key)))
+	index := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+
+	// 顺时针遍历哈希环，跳过已经选中的真实节点
+	items := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(m.keys) && len(items) < n; i++ {
+		item := m.hashMap[m.keys[(index+i)%len(m.keys)]]
+		if !seen[item] {
+			seen[item] = true
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -68,6 +68,116 @@ func TestConsistency(t *testing.T) {
 	}
 }
 
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(i)
+	})
+
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	testCases := map[string][]string{
+		"3":  {"4", "6"},
+		"11": {"2", "4", "6"},
+		"27": {"2", "4", "6"},
+	}
+	for k, want := range testCases {
+		got := hash.GetN(k, len(want))
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("GetN(%s, %d) = %v; want %v", k, len(want), got, want)
+		}
+		if got[0] != hash.Get(k) {
+			t.Errorf("GetN(%s)[0] = %s; want Get = %s", k, got[0], hash.Get(k))
+		}
+	}
+	if got := hash.GetN("3", 5); len(got) != 3 {
+		t.Errorf("GetN(3, 5) = %v; want all 3 items", got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(i)
+	})
+
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+	hash.Add("4")
+	if len(hash.keys) != 9 {
+		t.Errorf("ring has %d replicas after adding a key twice; want 9", len(hash.keys))
+	}
+
+	// 2, 6, 12, 16, 22, 26
+	hash.Remove("4")
+	testCases := map[string]string{
+		"3":  "6",
+		"11": "2",
+		"23": "6",
+		"27": "2",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s after Remove, should have yielded %s", k, v)
+		}
+	}
+
+	hash.Remove("6")
+	hash.Remove("2")
+	if !hash.IsEmpty() {
+		t.Errorf("hash not empty after removing every key")
+	}
+}
+
+func TestRemoveMovesOnlyItsItems(t *testing.T) {
+	hash := New(50, nil)
+	var nodes []string
+	for i := 0; i < 10; i++ {
+		nodes = append(nodes, fmt.Sprintf("node-%d", i))
+	}
+	hash.Add(nodes...)
+
+	const n = 10000
+	before := make([]string, n)
+	for i := range before {
+		before[i] = hash.Get(fmt.Sprint("key", i))
+	}
+
+	// 只有原来属于被删除节点的 key 会移动
+	hash.Remove("node-3")
+	moved := 0
+	for i, owner := range before {
+		got := hash.Get(fmt.Sprint("key", i))
+		if got == "node-3" {
+			t.Fatalf("key%d still maps to the removed node", i)
+		}
+		if got != owner {
+			moved++
+			if owner != "node-3" {
+				t.Errorf("key%d moved from %s to %s; only the removed node's keys should move", i, owner, got)
+			}
+		}
+	}
+	if moved == 0 || moved > n/5 {
+		t.Errorf("%d of %d keys moved after removing 1 of 10 nodes", moved, n)
+	}
+
+	// adding the node back restores the original mapping
+	hash.Add("node-3")
+	for i, owner := range before {
+		if got := hash.Get(fmt.Sprint("key", i)); got != owner {
+			t.Fatalf("key%d maps to %s after re-adding; want %s", i, got, owner)
+		}
+	}
+}
+
 func BenchmarkGet8(b *testing.B)   { benchmarkGet(b, 8) }
 func BenchmarkGet32(b *testing.B)  { benchmarkGet(b, 32) }
 func BenchmarkGet128(b *testing.B) { benchmarkGet(b, 128) }
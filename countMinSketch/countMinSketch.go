@@ -0,0 +1,96 @@
+// Package countMinSketch estimates how often keys have been seen, in a
+// fixed amount of memory, with counts that age so that keys popular long
+// ago are forgotten.
+package countMinSketch
+
+import "hash/fnv"
+
+const maxCount = 255
+
+// Sketch is a count-min sketch: each key increments one counter in each
+// row, and its estimated count is the smallest of them. Estimates are
+// never below the true count, and only exceed it on hash collisions.
+// Once resetAfter increments have been made, every counter is halved.
+// It is not safe for concurrent access.
+type Sketch struct {
+	width      uint64
+	rows       [][]uint8
+	additions  int
+	resetAfter int
+}
+
+// New creates a Sketch of depth rows of width counters.
+// If resetAfter is zero, counts never age.
+func New(width, depth, resetAfter int) *Sketch {
+	if width < 1 {
+		width = 1
+	}
+	if depth < 1 {
+		depth = 1
+	}
+	s := &Sketch{
+		width:      uint64(width),
+		rows:       make([][]uint8, depth),
+		resetAfter: resetAfter,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// index returns the counter of key in row i, using double hashing
+// so that a single hash serves all rows.
+func (s *Sketch) index(hash uint64, i int) uint64 {
+	h1, h2 := hash, (hash>>32)|1
+	return (h1 + uint64(i)*h2) % s.width
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// Increment counts one more occurrence of key and returns its new estimate.
+func (s *Sketch) Increment(key string) int {
+	hash := hashKey(key)
+	min := maxCount
+	for i, row := range s.rows {
+		j := s.index(hash, i)
+		if row[j] < maxCount {
+			row[j]++
+		}
+		if int(row[j]) < min {
+			min = int(row[j])
+		}
+	}
+
+	s.additions++
+	if s.resetAfter > 0 && s.additions >= s.resetAfter {
+		s.Reset()
+	}
+	return min
+}
+
+// Estimate returns how many times key has been seen.
+func (s *Sketch) Estimate(key string) int {
+	hash := hashKey(key)
+	min := maxCount
+	for i, row := range s.rows {
+		if c := int(row[s.index(hash, i)]); c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// Reset ages the sketch by halving every counter.
+func (s *Sketch) Reset() {
+	for _, row := range s.rows {
+		for j := range row {
+			row[j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
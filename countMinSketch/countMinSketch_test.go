@@ -0,0 +1,58 @@
+package countMinSketch
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestIncrement(t *testing.T) {
+	s := New(1024, 4, 0)
+	for i := 1; i <= 5; i++ {
+		if got := s.Increment("hot"); got != i {
+			t.Fatalf("Increment #%d = %d; want %d", i, got, i)
+		}
+	}
+	if got := s.Estimate("hot"); got != 5 {
+		t.Errorf("Estimate hot = %d; want 5", got)
+	}
+	if got := s.Estimate("cold"); got != 0 {
+		t.Errorf("Estimate cold = %d; want 0", got)
+	}
+}
+
+func TestNeverUnderestimates(t *testing.T) {
+	s := New(64, 4, 0)
+	counts := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		key := strconv.Itoa(i % 300)
+		s.Increment(key)
+		counts[key]++
+	}
+	for key, want := range counts {
+		if got := s.Estimate(key); got < want {
+			t.Errorf("Estimate %s = %d; want at least %d", key, got, want)
+		}
+	}
+}
+
+func TestAging(t *testing.T) {
+	s := New(1024, 4, 10)
+	for i := 0; i < 8; i++ {
+		s.Increment("old")
+	}
+	s.Increment("a")
+	s.Increment("b")
+	if got := s.Estimate("old"); got != 4 {
+		t.Errorf("Estimate old after reset = %d; want 4", got)
+	}
+}
+
+func TestSaturates(t *testing.T) {
+	s := New(16, 2, 0)
+	for i := 0; i < 1000; i++ {
+		s.Increment("key")
+	}
+	if got := s.Estimate("key"); got != maxCount {
+		t.Errorf("Estimate = %d; want %d", got, maxCount)
+	}
+}
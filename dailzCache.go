@@ -1,10 +1,15 @@
-package main
+package dailzCache
 
 import (
-	pb "dailzCache/dailzCachepb"
-	"dailzCache/singleFlight"
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/dailz1/dailzCache/dailzCachepb"
+	"github.com/dailz1/dailzCache/singleFlight"
 	"math/rand"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
 // A Getter loads data for a key.
@@ -20,6 +25,98 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// A ContextGetter loads data for a key, giving up when ctx is done.
+// A Getter passed to NewGroup that also implements ContextGetter is
+// always called through GetContext.
+type ContextGetter interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// A ContextGetterFunc implements ContextGetter with a function.
+type ContextGetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+// GetContext implements ContextGetter interface function
+func (f ContextGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// Get implements Getter interface function with a background context.
+func (f ContextGetterFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+// An ExpiringGetter loads data for a key together with the time it
+// expires. A zero expire means the data never expires, or expires after
+// the group's TTL if one is set.
+// A Getter passed to NewGroup that also implements ExpiringGetter is
+// always called through GetExpiring.
+type ExpiringGetter interface {
+	GetExpiring(ctx context.Context, key string) (value []byte, expire time.Time, err error)
+}
+
+// An ExpiringGetterFunc implements ExpiringGetter with a function.
+type ExpiringGetterFunc func(ctx context.Context, key string) ([]byte, time.Time, error)
+
+// GetExpiring implements ExpiringGetter interface function
+func (f ExpiringGetterFunc) GetExpiring(ctx context.Context, key string) ([]byte, time.Time, error) {
+	return f(ctx, key)
+}
+
+// Get implements Getter interface function with a background context.
+func (f ExpiringGetterFunc) Get(key string) ([]byte, error) {
+	value, _, err := f(context.Background(), key)
+	return value, err
+}
+
+// ErrGroupClosed is returned by the methods of a Group after Close.
+var ErrGroupClosed = errors.New("dailzCache: group is closed")
+
+// ErrValueTooLarge is returned for a value larger than
+// GroupOptions.MaxValueSize, which is not cached.
+var ErrValueTooLarge = errors.New("dailzCache: value too large")
+
+// errStaleRefresh fails a refresh that only got an expired value.
+var errStaleRefresh = errors.New("dailzCache: refresh got an expired value")
+
+// A NotFoundError is returned by a Getter when the key does not exist
+// in the origin. Groups with a NegativeTTL remember it for that long.
+type NotFoundError struct {
+	Key string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s not exist", e.Key)
+}
+
+// IsNotFound reports whether err is, or wraps, a *NotFoundError.
+func IsNotFound(err error) bool {
+	var nf *NotFoundError
+	return errors.As(err, &nf)
+}
+
+// A PeerRemoveError is returned by Set when the value was stored, but
+// some peers could not be told to drop their copies of the key, which
+// they may serve until the copies expire or are evicted.
+type PeerRemoveError struct {
+	Key string
+	Err error // the first error met
+}
+
+func (e *PeerRemoveError) Error() string {
+	return fmt.Sprintf("%s set, but not removed from peers: %v", e.Key, e.Err)
+}
+
+func (e *PeerRemoveError) Unwrap() error {
+	return e.Err
+}
+
+// A Setter persists a value written with Group.Set to the origin.
+// When the Getter passed to NewGroup also implements Setter, the key
+// owner calls Set before caching the new value.
+type Setter interface {
+	Set(ctx context.Context, key string, value []byte) error
+}
+
 // A Group is a cache namespace and associated data loaded spread over
 // a group of 1 or more machines.
 type Group struct {
@@ -27,93 +124,481 @@ type Group struct {
 	getter     Getter
 	peersOnce  sync.Once
 	cacheBytes int64 // sum of mainCache and hotCache size
-	mainCache  cache
-	hotCache   cache
+	mainCache  shardedCache
+	hotCache   shardedCache
 	peers      PeerPicker
-	loadGroup  *singleFlight.Group
-	Stats      Stats
+	universe   *Universe // the universe the group is registered in
+	loadGroup  *singleFlight.Group[string, ByteView]
+	opts       GroupOptions
+	// janitorOnce starts the goroutine reclaiming expired entries
+	// the first time an expiring value is cached.
+	janitorOnce sync.Once
+	// refreshing holds the keys being refreshed in the background.
+	refreshing sync.Map
+	// peerLatency records how long owners take to answer, for HedgePercentile.
+	peerLatency latencies
+	// fills limits the pushes to replicas in flight, see fillReplicas.
+	fills chan struct{}
+	// gens counts the invalidations of the keys hashing to each element,
+	// see populateLoaded, and invalidated holds when the last one was,
+	// see localFill.
+	genMu       sync.Mutex
+	gens        [64]uint64
+	invalidated [64]time.Time
+	// ctx is cancelled by Close, which stops the background work.
+	ctx    context.Context
+	cancel context.CancelFunc
+	Stats  Stats
 }
 
-type Stats struct {
-	Gets           AtomicInt // any Get request, including from peers
-	CacheHits      AtomicInt // either cache was good
-	PeerLoads      AtomicInt // either remote load or remote cache hit (not an error)
-	PeerErrors     AtomicInt
-	Loads          AtomicInt // (gets - cacheHits)
-	LoadsDeduped   AtomicInt // after singleflight
-	LocalLoads     AtomicInt // total good local loads
-	LocalLoadErrs  AtomicInt // total bad local loads
-	ServerRequests AtomicInt // gets that came over the network from peers
-}
-
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-)
+// GroupOptions are the configurations of a Group.
+type GroupOptions struct {
+	// TTL specifies how long a loaded value stays valid when the Getter
+	// does not give it an expiration time.
+	// If zero, such values never expire.
+	TTL time.Duration
 
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
-	return newGroup(name, cacheBytes, getter, nil)
+	// ReclaimInterval specifies how often expired entries are removed
+	// from the caches. Expired entries are never returned in between.
+	// If zero, it defaults to one minute.
+	ReclaimInterval time.Duration
+
+	// NegativeTTL specifies how long a *NotFoundError returned by the
+	// Getter is cached, so that missing keys don't reach the origin on
+	// every Get. It should be short.
+	// If zero, missing keys are not cached.
+	NegativeTTL time.Duration
+
+	// StaleWhileRevalidate specifies how long a value is still returned
+	// after it expires, while a single background load refreshes it.
+	// Past that hard expiry, callers wait for the load as usual.
+	// If zero, expired values are never returned.
+	StaleWhileRevalidate time.Duration
+
+	// Policy specifies how the main and hot caches choose the entries
+	// to evict, e.g. TwoQueuePolicy for groups that see scans.
+	// If nil, it defaults to LRUPolicy.
+	Policy PolicyFunc
+
+	// CacheShards specifies how many independently locked shards the
+	// main and hot caches are split into, by key hash, so that a busy
+	// group does not serialize every Get on one lock. The shards share
+	// cacheBytes, but each evicts by its own policy, so eviction order
+	// is only approximately that of the Policy.
+	// If zero, it defaults to 1. runtime.GOMAXPROCS(0) is a good start.
+	CacheShards int
+
+	// HedgeDelay specifies how long to wait for the owner of a key before
+	// also asking the next peer on the ring, if the PeerPicker is a
+	// ReplicaPicker. The first value returned wins, the other request
+	// is cancelled. The next peer may be the current one, which then
+	// loads the key itself.
+	// If zero, and HedgePercentile is zero, requests are not hedged.
+	HedgeDelay time.Duration
+
+	// HedgePercentile, between 0 and 1, sets the hedge delay to that
+	// percentile of the recent latencies of owners, e.g. 0.95, but at
+	// least HedgeDelay. HedgeDelay is used until a few latencies are known.
+	HedgePercentile float64
+
+	// HotAdmission decides which values loaded from peers are copied
+	// into the hot cache.
+	// If nil, it defaults to NewFrequencyAdmission(0, 0).
+	HotAdmission AdmissionPolicy
+
+	// PeerTimeout limits each attempt to load a key from a peer.
+	// If zero, an attempt is only limited by the caller's context.
+	PeerTimeout time.Duration
+
+	// PeerRetries specifies how many more times a failed load from a
+	// peer is attempted before falling back.
+	PeerRetries int
+
+	// PeerBackoff specifies the wait before the first retry, doubled
+	// for each later one. The actual wait is random up to that.
+	// If zero, it defaults to 10ms.
+	PeerBackoff time.Duration
+
+	// PeerFallback specifies what to do when a peer cannot serve a key.
+	// If zero, it defaults to FallbackLocal.
+	PeerFallback FallbackPolicy
+
+	// ReplicaFills makes a replica of a key push the values it loads
+	// with the Getter to the other replicas, see
+	// HTTPPoolOptions.ReplicationFactor, so that they can serve the key
+	// without reaching the origin.
+	ReplicaFills bool
+
+	// LoadLinger specifies how long the result of a load, errors
+	// included, is handed to new callers of the key after the load
+	// finishes, absorbing bursts in front of a failing origin.
+	// Remove and Set drop a lingering result at once.
+	// Lingering results are held on top of cacheBytes, one for each key
+	// loaded within the last LoadLinger, see GroupStats.LingeringLoads.
+	// It is cut down to TTL, if set, and should stay well below the
+	// expiration times an ExpiringGetter gives, so that no result lingers
+	// past its expiry. Background refreshes never use a lingering result.
+	// If zero, every cache miss after a load finishes loads again.
+	LoadLinger time.Duration
+
+	// MaxValueSize specifies the largest value in bytes the group loads,
+	// stores or accepts from a peer; others fail with ErrValueTooLarge.
+	// Values larger than 256KB are kept in chunks whatever the limit.
+	// If zero, values of any size are allowed.
+	MaxValueSize int64
 }
 
-// NewGroup creates a new group, the name must be unique for each getter.
-func newGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker) *Group {
-	if getter == nil {
-		panic("nil Getter")
-	}
+// A FallbackPolicy decides how a key is loaded when its owner fails.
+type FallbackPolicy int
 
-	mu.Lock()
-	defer mu.Unlock()
+const (
+	// FallbackLocal loads the key with the local Getter.
+	FallbackLocal FallbackPolicy = iota
+	// FallbackError returns the error of the peer, leaving the origin
+	// alone while the owner is unreachable.
+	FallbackError
+	// FallbackNextReplica loads the key from the next peer on the ring,
+	// if the PeerPicker is a ReplicaPicker, and returns its error if it
+	// fails too. When the next peer is the current one, or there is
+	// none, the key is loaded locally.
+	// The other replicas of a replicated key are tried in order whatever
+	// the policy, FallbackNextReplica only extends that to one more peer
+	// for keys that are not replicated.
+	FallbackNextReplica
+)
 
-	if _, ok := groups[name]; ok {
-		panic("duplicate registration of group " + name)
-	}
+const (
+	defaultReclaimInterval = time.Minute
+	defaultPeerBackoff     = 10 * time.Millisecond
 
-	g := &Group{
-		name:       name,
-		getter:     getter,
-		cacheBytes: cacheBytes,
-		peers:      peers,
-		loadGroup:  &singleFlight.Group{},
-	}
-	groups[name] = g
-	return g
+	// maxReplicaFills limits the pushes to replicas in flight per group,
+	// and replicaFillTimeout each push when PeerTimeout is zero.
+	maxReplicaFills    = 64
+	replicaFillTimeout = 10 * time.Second
+)
+
+type Stats struct {
+	Gets             AtomicInt // any Get request, including from peers
+	CacheHits        AtomicInt // either cache was good
+	PeerLoads        AtomicInt // either remote load or remote cache hit (not an error)
+	PeerErrors       AtomicInt
+	Loads            AtomicInt // (gets - cacheHits)
+	LoadsDeduped     AtomicInt // after singleflight
+	LocalLoads       AtomicInt // total good local loads
+	LocalLoadErrs    AtomicInt // total bad local loads
+	NegativeHits     AtomicInt // cache hits on keys known not to exist
+	StaleServes      AtomicInt // expired values returned while being refreshed
+	Refreshes        AtomicInt // background refreshes started
+	RefreshErrors    AtomicInt // background refreshes that failed
+	HotAdmits        AtomicInt // peer loads copied into the hot cache
+	HotRejects       AtomicInt // peer loads not hot enough to be copied
+	PeerTimeouts     AtomicInt // peer attempts that ran out of PeerTimeout
+	PeerRetries      AtomicInt // peer attempts after a failed one
+	FallbackLocals   AtomicInt // failed peer loads then loaded locally
+	FallbackErrors   AtomicInt // failed peer loads returned as errors
+	FallbackPeers    AtomicInt // failed peer loads then tried on the next replica
+	ReplicaFills     AtomicInt // values pushed to the other replicas of a key
+	ReplicaFillErrs  AtomicInt // pushes to replicas that failed
+	ReplicaFillDrops AtomicInt // pushes to replicas dropped, too many in flight
+	Hedges           AtomicInt // hedged requests sent to the next peer
+	HedgeWins        AtomicInt // hedged requests that answered first
+}
+
+// NewGroup creates a new group, the name must be unique for each getter.
+func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+	return defaultUniverse.NewGroup(name, cacheBytes, getter)
+}
+
+// NewGroupOpts creates a new group with the given options.
+func NewGroupOpts(name string, cacheBytes int64, getter Getter, opts *GroupOptions) *Group {
+	return defaultUniverse.NewGroupOpts(name, cacheBytes, getter, opts)
 }
 
 func GetGroup(name string) *Group {
-	mu.RLock()
-	defer mu.RUnlock()
-	g := groups[name]
-	return g
+	return defaultUniverse.GetGroup(name)
+}
+
+// ReplaceGroup creates a new group in place of the group with the same
+// name, which is closed, see Universe.ReplaceGroup.
+func ReplaceGroup(name string, cacheBytes int64, getter Getter, opts *GroupOptions) *Group {
+	return defaultUniverse.ReplaceGroup(name, cacheBytes, getter, opts)
+}
 
+// UnregisterGroup removes the named group and closes it.
+// It reports whether there was such a group.
+func UnregisterGroup(name string) bool {
+	return defaultUniverse.UnregisterGroup(name)
 }
 
 func (g *Group) Name() string {
 	return g.name
 }
 
+// CacheType represents a type of cache.
+type CacheType int
+
+const (
+	// The MainCache is the cache for items that this peer is the
+	// owner for.
+	MainCache CacheType = iota + 1
+
+	// The HotCache is the cache for items that seem popular
+	// enough to replicate to this node, even though it's not the
+	// owner.
+	HotCache
+)
+
+// CacheStats returns stats about the provided cache within the group.
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	default:
+		return CacheStats{}
+	}
+}
+
+// Close unregisters the group if it is still registered under its name,
+// stops its background work and frees both caches. Afterwards the
+// methods of the group return ErrGroupClosed. Close is idempotent.
+func (g *Group) Close() {
+	g.universe.unregister(g)
+	g.cancel()
+	g.mainCache.close()
+	g.hotCache.close()
+}
+
+func (g *Group) closed() bool {
+	return g.ctx.Err() != nil
+}
+
 func (g *Group) initPeers() {
 	if g.peers == nil {
-		g.peers = GetPeers(g.name)
+		g.peers = g.universe.GetPeers(g.name)
 	}
 }
 
 // Get value for a key from cache
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext is like Get, but the load of a missing key, whether from
+// a peer or from the Getter, is abandoned once ctx is done.
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
+	if g.closed() {
+		return ByteView{}, ErrGroupClosed
+	}
 	g.peersOnce.Do(g.initPeers)
 	g.Stats.Gets.Add(1)
 
 	value, cacheHit := g.lookupCache(key)
-	if cacheHit {
-		return value, nil
+	if cacheHit && !(isRefresh(ctx) && value.expired(time.Now())) {
+		return g.cacheHit(key, value)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return ByteView{}, err
 	}
 
-	value, err := g.load(key)
+	value, err := g.load(ctx, key)
 	if err != nil {
 		return ByteView{}, err
 	}
 	return value, err
 }
 
+// cacheHit returns the result of Get for a value found in the caches.
+func (g *Group) cacheHit(key string, value ByteView) (ByteView, error) {
+	if value.expired(time.Now()) {
+		// 软过期的数据先返回给调用方，同时在后台刷新
+		g.Stats.StaleServes.Add(1)
+		g.refresh(key)
+	}
+	if value.notFound {
+		g.Stats.NegativeHits.Add(1)
+		return ByteView{}, &NotFoundError{Key: key}
+	}
+	return value, nil
+}
+
+// Remove removes the key from the caches of every peer.
+func (g *Group) Remove(key string) error {
+	return g.RemoveContext(context.Background(), key)
+}
+
+// RemoveContext removes the key from the owner's cache first, so that no
+// peer can copy the old value again, then from the current peer and the
+// hot caches of all the others. It returns the first error met, but goes
+// on removing the key from the remaining peers.
+func (g *Group) RemoveContext(ctx context.Context, key string) error {
+	if g.closed() {
+		return ErrGroupClosed
+	}
+	g.peersOnce.Do(g.initPeers)
+
+	var firstErr error
+	owner, ok := g.peers.PickPeer(key)
+	if ok {
+		firstErr = g.removeFromPeer(ctx, key, owner)
+	}
+	g.invalidate(key)
+
+	// 通知其他 peer 删除热点备份
+	var others []ProtoGetter
+	for _, peer := range g.peers.GetAll() {
+		if !ok || peer != owner {
+			others = append(others, peer)
+		}
+	}
+	if err := g.removeFromPeers(ctx, key, others); firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// Set writes the value for the key through to the key owner.
+func (g *Group) Set(key string, value []byte) error {
+	return g.SetContext(context.Background(), key, value)
+}
+
+// SetContext sends the value to the owner of the key, which persists it
+// with the Setter if there is one, caches it in its main cache and
+// removes the copies other peers keep in their hot caches. If only the
+// removal fails, the error is a *PeerRemoveError.
+func (g *Group) SetContext(ctx context.Context, key string, value []byte) error {
+	if g.closed() {
+		return ErrGroupClosed
+	}
+	if g.tooLarge(len(value)) {
+		return ErrValueTooLarge
+	}
+	g.peersOnce.Do(g.initPeers)
+
+	if owner, ok := g.peers.PickPeer(key); ok {
+		req := &pb.SetRequest{
+			Group: g.name,
+			Key:   key,
+			Value: value,
+		}
+		err := owner.Set(ctx, req)
+		var removeErr *PeerRemoveError
+		if err != nil && !errors.As(err, &removeErr) {
+			return err
+		}
+		// owner 已经写入新值，即使它没能通知到所有节点，本节点的副本也要删除
+		g.invalidate(key)
+		return err
+	}
+	return g.localSet(ctx, key, value)
+}
+
+// localSet stores the value of a key owned by the current peer.
+func (g *Group) localSet(ctx context.Context, key string, value []byte) error {
+	if g.closed() {
+		return ErrGroupClosed
+	}
+	if g.tooLarge(len(value)) {
+		return ErrValueTooLarge
+	}
+	g.peersOnce.Do(g.initPeers)
+	if setter, ok := g.getter.(Setter); ok {
+		if err := setter.Set(ctx, key, value); err != nil {
+			return err
+		}
+	}
+
+	view := makeView(value)
+	if g.opts.TTL > 0 {
+		view.e = time.Now().Add(g.opts.TTL)
+	}
+	g.invalidate(key)
+	g.populateCache(key, view, &g.mainCache)
+
+	// 新值只保存在 owner 上，其他 peer 的热点备份需要删除
+	if err := g.removeFromPeers(ctx, key, g.peers.GetAll()); err != nil {
+		return &PeerRemoveError{Key: key, Err: err}
+	}
+	return nil
+}
+
+// localRemove removes the key from the current peer's caches.
+func (g *Group) localRemove(key string) {
+	if g.cacheBytes <= 0 {
+		return
+	}
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+}
+
+// invalidate removes the key from the current peer's caches after its
+// value changed, along with the result of a load lingering for it.
+// Loads of the key still running don't cache what they load.
+func (g *Group) invalidate(key string) {
+	g.genMu.Lock()
+	i := fnv32a(key) % uint32(len(g.gens))
+	g.gens[i]++
+	g.invalidated[i] = time.Now()
+	g.genMu.Unlock()
+	g.loadGroup.Forget(key)
+	g.localRemove(key)
+}
+
+type loadGenKey struct{}
+
+// startLoad returns ctx recording the generation of key, see
+// populateLoaded.
+func (g *Group) startLoad(ctx context.Context, key string) context.Context {
+	g.genMu.Lock()
+	defer g.genMu.Unlock()
+	return context.WithValue(ctx, loadGenKey{}, g.gens[fnv32a(key)%uint32(len(g.gens))])
+}
+
+// populateLoaded caches a value loaded with ctx, unless the key has been
+// invalidated since startLoad, in which case the value may be older than
+// what Set or Remove left. It reports whether the value was cached.
+func (g *Group) populateLoaded(ctx context.Context, key string, value ByteView, cache *shardedCache) bool {
+	g.genMu.Lock()
+	defer g.genMu.Unlock()
+	if gen, ok := ctx.Value(loadGenKey{}).(uint64); ok && gen != g.gens[fnv32a(key)%uint32(len(g.gens))] {
+		return false
+	}
+	g.populateCache(key, value, cache)
+	return true
+}
+
+// removeFromPeers removes the key from the peers concurrently
+// and returns the first error met.
+func (g *Group) removeFromPeers(ctx context.Context, key string, peers []ProtoGetter) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(peers))
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer ProtoGetter) {
+			defer wg.Done()
+			errs <- g.removeFromPeer(ctx, key, peer)
+		}(peer)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Group) removeFromPeer(ctx context.Context, key string, peer ProtoGetter) error {
+	req := &pb.RemoveRequest{
+		Group: g.name,
+		Key:   key,
+	}
+	return peer.Remove(ctx, req)
+}
+
 func (g *Group) RegisterPeers(peers PeerPicker) {
 	if g.peers != nil {
 		panic("RegisterPeerPicker called more than once")
@@ -122,39 +607,321 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 }
 
 // load loads key either by invoking the getter locally or by sending it to another machine.
-func (g *Group) load(key string) (ByteView, error) {
+// The values of ctx of the caller that starts the load are passed on to the
+// peer and the getter, which are cancelled once every caller waiting for the
+// load has gone away.
+func (g *Group) load(ctx context.Context, key string) (ByteView, error) {
 	g.Stats.Loads.Add(1)
-	view, err := g.loadGroup.Do(key, func() (interface{}, error) {
-		if value, cacheHit := g.lookupCache(key); cacheHit {
-			g.Stats.CacheHits.Add(1)
-			return value, nil
-		}
-		g.Stats.LoadsDeduped.Add(1)
-		// 1.从一致性哈希中获取到存有 key 的 peer
-		if peer, ok := g.peers.PickPeer(key); ok {
-			// 2.使用 http 从刚刚获取到的 peer 中获取 key 对应的 value
-			value, err := g.getFromPeer(key, peer)
-			if err == nil {
-				g.Stats.PeerLoads.Add(1)
-				return value, nil
+	view, err, _ := g.loadGroup.Do(ctx, key, func(ctx context.Context) (ByteView, error) {
+		return g.doLoad(ctx, key)
+	})
+	return view, err
+}
+
+// refresh reloads the stale value of key in the background,
+// unless a refresh of key is already running.
+func (g *Group) refresh(key string) {
+	if g.closed() {
+		return
+	}
+	if _, loaded := g.refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	g.Stats.Refreshes.Add(1)
+	go func() {
+		defer g.refreshing.Delete(key)
+		// 不经过 loadGroup：refreshing 已经保证同一 key 只有一次刷新，
+		// 而 loadGroup 中保留的结果可能正是过期的旧值
+		value, err := g.doLoad(withRefresh(g.ctx), key)
+		if err == nil && value.expired(time.Now()) {
+			// 不认识 refresh 标记的 peer 仍可能返回过期的值
+			err = errStaleRefresh
+		}
+		if IsNotFound(err) {
+			// the key is gone, stop serving its stale value
+			if value, ok := g.lookupCache(key); ok && !value.notFound {
+				g.localRemove(key)
 			}
-			g.Stats.PeerErrors.Add(1)
+			return
 		}
-		value, err := g.getLocally(key)
-		if err != nil {
-			g.Stats.LocalLoadErrs.Add(1)
-			return ByteView{}, err
+		if err != nil && !g.closed() {
+			g.Stats.RefreshErrors.Add(1)
+		}
+	}()
+}
+
+// doLoad is the body of a deduplicated load.
+func (g *Group) doLoad(ctx context.Context, key string) (ByteView, error) {
+	ctx = g.startLoad(ctx, key)
+	// 软过期的数据需要重新加载，不能当作命中
+	value, cacheHit := g.lookupCache(key)
+	if cacheHit && !value.expired(time.Now()) {
+		g.Stats.CacheHits.Add(1)
+		if value.notFound {
+			g.Stats.NegativeHits.Add(1)
+			return ByteView{}, &NotFoundError{Key: key}
 		}
-		g.Stats.LocalLoads.Add(1)
-		g.populateCache(key, value, &g.mainCache)
 		return value, nil
+	}
+	stale := cacheHit
+	g.Stats.LoadsDeduped.Add(1)
+	// 其他节点转发来的请求直接在本地加载
+	if isPeerRequest(ctx) {
+		return g.loadLocally(ctx, key)
+	}
+	// 1.从一致性哈希中获取到存有 key 的 peer
+	peer, ok := g.peers.PickPeer(key)
+	if !ok {
+		return g.loadLocally(ctx, key)
+	}
+	// 2.使用 http 从刚刚获取到的 peer 中获取 key 对应的 value
+	value, err := g.hedgedLoad(ctx, key, peer, stale)
+	if final(err) || ctx.Err() != nil {
+		return value, err
+	}
+	// 3.peer 加载失败，按照 PeerFallback 处理
+	return g.fallback(ctx, key, err, stale)
+}
+
+// fallback loads key after its owner failed with err. The other
+// replicas of the key are tried in order first, then PeerFallback applies.
+func (g *Group) fallback(ctx context.Context, key string, err error, stale bool) (ByteView, error) {
+	triedReplica := false
+	if picker, ok := g.peers.(ReplicaPicker); ok {
+		n := picker.Replication()
+		if g.opts.PeerFallback == FallbackNextReplica && n < 2 {
+			n = 2
+		}
+		peers := picker.PickPeers(key, n)
+		for i := 1; i < len(peers); i++ {
+			if peers[i] == nil {
+				// 本节点也是 key 的副本，直接在本地加载
+				g.Stats.FallbackLocals.Add(1)
+				return g.loadLocally(ctx, key)
+			}
+			g.Stats.FallbackPeers.Add(1)
+			triedReplica = true
+			var value ByteView
+			value, err = g.loadFromPeer(ctx, key, peers[i], stale)
+			if final(err) || ctx.Err() != nil {
+				return value, err
+			}
+		}
+	}
+
+	switch g.opts.PeerFallback {
+	case FallbackError:
+		g.Stats.FallbackErrors.Add(1)
+		return ByteView{}, err
+	case FallbackNextReplica:
+		if triedReplica {
+			g.Stats.FallbackErrors.Add(1)
+			return ByteView{}, err
+		}
+	}
+	g.Stats.FallbackLocals.Add(1)
+	return g.loadLocally(ctx, key)
+}
+
+// loadFromPeer loads key from peer, retrying as configured.
+// A stale copy of the value is replaced when the load succeeds.
+func (g *Group) loadFromPeer(ctx context.Context, key string, peer ProtoGetter, stale bool) (ByteView, error) {
+	var value ByteView
+	err := g.retryPeer(ctx, func(ctx context.Context) (err error) {
+		value, err = g.getFromPeer(ctx, key, peer)
+		return err
 	})
 	if err == nil {
-		return view.(ByteView), nil
+		g.Stats.PeerLoads.Add(1)
+		if stale {
+			// replace the stale copy, wherever it was kept
+			g.localRemove(key)
+			g.populateLoaded(ctx, key, value, &g.hotCache)
+		}
+		return value, nil
+	}
+	if IsNotFound(err) {
+		// the owner already asked the origin, don't ask again
+		g.Stats.PeerLoads.Add(1)
+		return ByteView{}, err
+	}
+	if ctx.Err() != nil {
+		// the caller is gone, don't fall back to the getter
+		return ByteView{}, ctx.Err()
 	}
 	return ByteView{}, err
 }
 
+// final reports whether err settles a load, so that asking the same or
+// another peer again would not change it.
+func final(err error) bool {
+	return err == nil || IsNotFound(err) || err == ErrValueTooLarge
+}
+
+// retryPeer calls try until it succeeds or finds the key does not exist,
+// at most PeerRetries more times after the first failure, waiting a
+// jittered backoff in between. Each call is limited by PeerTimeout.
+func (g *Group) retryPeer(ctx context.Context, try func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := g.tryPeer(ctx, try)
+		if final(err) {
+			return err
+		}
+		if ctx.Err() != nil {
+			// the caller gave up, or a hedged request won
+			return err
+		}
+		g.Stats.PeerErrors.Add(1)
+		if attempt >= g.opts.PeerRetries {
+			return err
+		}
+
+		g.Stats.PeerRetries.Add(1)
+		timer := time.NewTimer(g.peerBackoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// tryPeer calls try with ctx limited by PeerTimeout.
+func (g *Group) tryPeer(ctx context.Context, try func(ctx context.Context) error) error {
+	if g.opts.PeerTimeout <= 0 {
+		return try(ctx)
+	}
+	peerCtx, cancel := context.WithTimeout(ctx, g.opts.PeerTimeout)
+	defer cancel()
+	err := try(peerCtx)
+	if err != nil && ctx.Err() == nil && peerCtx.Err() == context.DeadlineExceeded {
+		g.Stats.PeerTimeouts.Add(1)
+	}
+	return err
+}
+
+// peerBackoff returns a random wait before the retry following attempt,
+// up to PeerBackoff doubled attempt times ("full jitter").
+func (g *Group) peerBackoff(attempt int) time.Duration {
+	backoff := g.opts.PeerBackoff
+	if backoff <= 0 {
+		backoff = defaultPeerBackoff
+	}
+	if attempt > 16 {
+		attempt = 16
+	}
+	return time.Duration(rand.Int63n(int64(backoff)<<attempt) + 1)
+}
+
+// loadLocally loads key with the getter and caches the result.
+func (g *Group) loadLocally(ctx context.Context, key string) (ByteView, error) {
+	start := time.Now()
+	value, err := g.getLocally(ctx, key)
+	value.cost = time.Since(start)
+	if err != nil {
+		g.Stats.LocalLoadErrs.Add(1)
+		if IsNotFound(err) && g.opts.NegativeTTL > 0 {
+			g.populateLoaded(ctx, key, g.negativeView(), &g.mainCache)
+		}
+		return ByteView{}, err
+	}
+	g.Stats.LocalLoads.Add(1)
+	// 加载期间 key 被 Set 或 Remove 过，旧值也不能推送给副本
+	if g.populateLoaded(ctx, key, value, &g.mainCache) && g.opts.ReplicaFills {
+		g.fillReplicas(key, value)
+	}
+	return value, nil
+}
+
+// fillReplicas pushes a value loaded by the getter to the other
+// replicas of the key in the background, if this peer is one of them.
+// Each push is limited by fillTimeout, and pushes are dropped while
+// maxReplicaFills are in flight; a replica missing a push loads the
+// key itself.
+func (g *Group) fillReplicas(key string, value ByteView) {
+	picker, ok := g.peers.(ReplicaPicker)
+	if !ok {
+		return
+	}
+	var replicas []ProtoGetter
+	isReplica := false
+	for _, peer := range picker.PickPeers(key, picker.Replication()) {
+		if peer == nil {
+			isReplica = true
+		} else {
+			replicas = append(replicas, peer)
+		}
+	}
+	if !isReplica {
+		return
+	}
+
+	req := &pb.SetRequest{
+		Group: g.name,
+		Key:   key,
+		Value: value.rawBytes(),
+		Fill:  true,
+	}
+	if e := value.Expire(); !e.IsZero() {
+		req.Expire = e.UnixNano()
+	}
+	timeout := g.fillTimeout()
+	for _, peer := range replicas {
+		select {
+		case g.fills <- struct{}{}:
+		default:
+			g.Stats.ReplicaFillDrops.Add(1)
+			continue
+		}
+		go func(peer ProtoGetter) {
+			defer func() { <-g.fills }()
+			// 副本没有响应时不能让推送一直挂着
+			ctx, cancel := context.WithTimeout(g.ctx, timeout)
+			defer cancel()
+			if err := peer.Set(ctx, req); err != nil {
+				g.Stats.ReplicaFillErrs.Add(1)
+				return
+			}
+			g.Stats.ReplicaFills.Add(1)
+		}(peer)
+	}
+}
+
+// fillTimeout returns how long a push to a replica may take.
+func (g *Group) fillTimeout() time.Duration {
+	if g.opts.PeerTimeout > 0 {
+		return g.opts.PeerTimeout
+	}
+	return replicaFillTimeout
+}
+
+// localFill caches a value pushed by an owner of the key. A push may
+// arrive after the removal sent by a Set or Remove of the key that ran
+// once the push had started, so it is dropped if the key was
+// invalidated within the last fillTimeout; the key is then loaded when
+// it is next read.
+func (g *Group) localFill(key string, value []byte, expire int64) error {
+	if g.closed() {
+		return ErrGroupClosed
+	}
+	if g.tooLarge(len(value)) {
+		return ErrValueTooLarge
+	}
+	view := viewOf(value)
+	if expire != 0 {
+		view.e = time.Unix(0, expire)
+	}
+	g.genMu.Lock()
+	defer g.genMu.Unlock()
+	if time.Since(g.invalidated[fnv32a(key)%uint32(len(g.invalidated))]) < g.fillTimeout() {
+		return nil
+	}
+	g.localRemove(key)
+	g.populateCache(key, view, &g.mainCache)
+	return nil
+}
+
 func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
 	if g.cacheBytes <= 0 {
 		return
@@ -167,51 +934,137 @@ func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
 	return
 }
 
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	bytes, expire, err := g.callGetter(ctx, key)
 	if err != nil {
 		//fmt.Println(err)
 		return ByteView{}, err
 	}
-	value := ByteView{data: cloneBytes(bytes)}
+	if g.tooLarge(len(bytes)) {
+		return ByteView{}, ErrValueTooLarge
+	}
+	if expire.IsZero() && g.opts.TTL > 0 {
+		expire = time.Now().Add(g.opts.TTL)
+	}
+	value := makeView(bytes)
+	value.e = expire
 	//g.populateCache(key, value)
 	return value, nil
 }
 
-func (g *Group) getFromPeer(key string, peer ProtoGetter) (ByteView, error) {
+// callGetter calls the Getter for key. A panic of the Getter is returned
+// as an error: the load may run with no caller left to receive the panic,
+// and must not take the whole peer down then.
+func (g *Group) callGetter(ctx context.Context, key string) (bytes []byte, expire time.Time, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("dailzCache: Getter panicked loading %s: %v\n\n%s", key, r, debug.Stack())
+		}
+	}()
+	switch getter := g.getter.(type) {
+	case ExpiringGetter:
+		return getter.GetExpiring(ctx, key)
+	case ContextGetter:
+		bytes, err = getter.GetContext(ctx, key)
+	default:
+		bytes, err = getter.Get(key)
+	}
+	return bytes, time.Time{}, err
+}
+
+func (g *Group) getFromPeer(ctx context.Context, key string, peer ProtoGetter) (ByteView, error) {
 	req := &pb.GetRequest{
-		Group: g.name,
-		Key:   key,
+		Group:   g.name,
+		Key:     key,
+		Refresh: isRefresh(ctx),
 	}
 	res := &pb.GetResponse{}
+	if g.opts.MaxValueSize > 0 {
+		ctx = withMaxValueSize(ctx, g.opts.MaxValueSize)
+	}
+
+	start := time.Now()
+	err := peer.Get(ctx, req, res)
+	return g.fromPeer(ctx, key, res, err, time.Since(start))
+}
 
-	err := peer.Get(req, res)
+// fromPeer turns the response of a peer for key, which took cost to
+// get with ctx, into a ByteView.
+func (g *Group) fromPeer(ctx context.Context, key string, res *pb.GetResponse, err error, cost time.Duration) (ByteView, error) {
 	if err != nil {
+		if IsNotFound(err) && g.opts.NegativeTTL > 0 && g.admitHot(key) {
+			g.populateLoaded(ctx, key, g.negativeView(), &g.hotCache)
+		}
 		return ByteView{}, err
 	}
 
-	value := ByteView{data: res.Value}
-	//在本地 peer 中备份热点数据，备份依据是根据随机数进行判断，有十分之一的概率进行备份
-	// 这样，如果每个 peer 存储 1G 数据，那么是个 peer 就能存储 10 G 数据，然后有 1G 的热点数据，因此就能存储 9G 数据
-	if rand.Intn(10) == 0 {
-		g.populateCache(key, value, &g.hotCache)
+	value := viewOf(res.Value)
+	if len(res.Chunks) > 0 {
+		// 大的值按块保存，不再拼接成一整块
+		value = ByteView{chunks: res.Chunks}
+	}
+	value.cost = cost
+	if g.tooLarge(value.Len()) {
+		return ByteView{}, ErrValueTooLarge
+	}
+	// 沿用 owner 给出的过期时间，保证热点备份不会比 owner 的数据活得更久
+	if res.Expire != 0 {
+		value.e = time.Unix(0, res.Expire)
+	}
+	// 在本地 peer 中备份热点数据，只有经常从其他 peer 加载的 key 才会被备份，
+	// 避免冷数据挤占 hotCache
+	if g.admitHot(key) {
+		g.populateLoaded(ctx, key, value, &g.hotCache)
 	}
 	return value, nil
 }
-func (g *Group) populateCache(key string, value ByteView, cache *cache) {
+
+// tooLarge reports whether a value of n bytes exceeds MaxValueSize.
+func (g *Group) tooLarge(n int) bool {
+	return g.opts.MaxValueSize > 0 && int64(n) > g.opts.MaxValueSize
+}
+
+// admitHot reports whether the key loaded from a peer goes into the hot cache.
+func (g *Group) admitHot(key string) bool {
+	if g.opts.HotAdmission.Admit(key) {
+		g.Stats.HotAdmits.Add(1)
+		return true
+	}
+	g.Stats.HotRejects.Add(1)
+	return false
+}
+
+// negativeView returns the entry caching that a key does not exist.
+func (g *Group) negativeView() ByteView {
+	return ByteView{notFound: true, e: time.Now().Add(g.opts.NegativeTTL)}
+}
+
+func (g *Group) populateCache(key string, value ByteView, cache *shardedCache) {
 	if g.cacheBytes <= 0 {
 		return
 	}
 
 	cache.add(key, value)
+	if !value.e.IsZero() {
+		g.janitorOnce.Do(func() { go g.janitor() })
+	}
 
 	// 判断是否超出 g.cacheByte，如果是，那就需要进行删除
+	reclaimed := false
 	for {
 		mainBytes := g.mainCache.bytes()
 		hotBytes := g.hotCache.bytes()
 		if mainBytes+hotBytes <= g.cacheBytes {
 			return
 		}
+		// 优先清理已过期的数据，仍然超出再淘汰最久未使用的数据
+		if !reclaimed {
+			reclaimed = true
+			now := time.Now()
+			g.mainCache.removeExpired(now)
+			g.hotCache.removeExpired(now)
+			continue
+		}
 		victim := &g.mainCache
 		if hotBytes > mainBytes/8 {
 			victim = &g.hotCache
@@ -219,3 +1072,18 @@ func (g *Group) populateCache(key string, value ByteView, cache *cache) {
 		victim.removeOldest()
 	}
 }
+
+// janitor periodically removes expired entries from both caches.
+func (g *Group) janitor() {
+	ticker := time.NewTicker(g.opts.ReclaimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			g.mainCache.removeExpired(now)
+			g.hotCache.removeExpired(now)
+		case <-g.ctx.Done():
+			return
+		}
+	}
+}
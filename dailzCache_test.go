@@ -1 +1,1155 @@
-package main
+package dailzCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/dailz1/dailzCache/dailzCachepb"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetContextPassesContextToGetter(t *testing.T) {
+	type ctxKey struct{}
+	g := NewUniverse().NewGroup("ctx-getter", 1<<10, ContextGetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			v, _ := ctx.Value(ctxKey{}).(string)
+			return []byte(key + v), nil
+		}))
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "-ctx")
+	view, err := g.GetContext(ctx, "key")
+	if err != nil {
+		t.Fatalf("GetContext error = %v", err)
+	}
+	if got, want := view.String(), "key-ctx"; got != want {
+		t.Errorf("GetContext = %q; want %q", got, want)
+	}
+}
+
+func TestGetContextDeadline(t *testing.T) {
+	g := NewUniverse().NewGroup("ctx-deadline", 1<<10, ContextGetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.GetContext(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetContext error = %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+type blockingPeers struct{}
+
+func (blockingPeers) PickPeer(key string) (ProtoGetter, bool) {
+	return blockingPeer{}, true
+}
+
+func (blockingPeers) GetAll() []ProtoGetter {
+	return []ProtoGetter{blockingPeer{}}
+}
+
+type blockingPeer struct{}
+
+func (blockingPeer) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockingPeer) Remove(ctx context.Context, in *pb.RemoveRequest) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockingPeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockingPeer) GetMulti(ctx context.Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestGetContextCancelledPeerSkipsGetter(t *testing.T) {
+	called := false
+	g := NewUniverse().NewGroup("ctx-peer", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		called = true
+		return []byte(key), nil
+	}))
+	g.RegisterPeers(blockingPeers{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.GetContext(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetContext error = %v; want %v", err, context.DeadlineExceeded)
+	}
+	if called {
+		t.Errorf("getter called after the caller's context expired")
+	}
+}
+
+func TestGetterExpiration(t *testing.T) {
+	var loads int
+	expire := time.Now().Add(50 * time.Millisecond)
+	g := NewUniverse().NewGroup("ttl-getter", 1<<10, ExpiringGetterFunc(
+		func(ctx context.Context, key string) ([]byte, time.Time, error) {
+			loads++
+			return []byte(key), expire, nil
+		}))
+
+	for i := 0; i < 2; i++ {
+		view, err := g.Get("key")
+		if err != nil {
+			t.Fatalf("Get error = %v", err)
+		}
+		if !view.Expire().Equal(expire) {
+			t.Errorf("Expire = %v; want %v", view.Expire(), expire)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("loads = %d before expiry; want 1", loads)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	expire = time.Now().Add(time.Minute)
+	if _, err := g.Get("key"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if loads != 2 {
+		t.Errorf("loads = %d after expiry; want 2", loads)
+	}
+}
+
+func TestGroupTTL(t *testing.T) {
+	var loads int
+	g := NewUniverse().NewGroupOpts("ttl-group", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}), &GroupOptions{TTL: 20 * time.Millisecond})
+
+	before := time.Now()
+	view, err := g.Get("key")
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if e := view.Expire(); e.Before(before.Add(20*time.Millisecond)) || e.After(time.Now().Add(20*time.Millisecond)) {
+		t.Errorf("Expire = %v; want about 20ms from now", e)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := g.Get("key"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if loads != 2 {
+		t.Errorf("loads = %d; want 2", loads)
+	}
+}
+
+func TestCacheRemoveExpired(t *testing.T) {
+	var c cache
+	now := time.Now()
+	c.add("old", ByteView{str: "v", e: now.Add(-time.Second)})
+	c.add("new", ByteView{str: "v", e: now.Add(time.Hour)})
+	c.add("forever", ByteView{str: "v"})
+	// replacing an entry must not let its stale expiry remove it
+	c.add("replaced", ByteView{str: "v", e: now.Add(-time.Second)})
+	c.add("replaced", ByteView{str: "v", e: now.Add(time.Hour)})
+
+	c.removeExpired(now)
+	if got, want := c.stats().Items, int64(3); got != want {
+		t.Fatalf("items = %d; want %d", got, want)
+	}
+	for _, key := range []string{"new", "forever", "replaced"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("%s was removed", key)
+		}
+	}
+}
+
+func TestCacheExpiriesStayBounded(t *testing.T) {
+	c := cache{newPolicy: LRUPolicy()}
+	e := time.Now().Add(time.Hour)
+	for i := 0; i < 1000; i++ {
+		c.add("evicted", ByteView{str: "v", e: e.Add(time.Duration(i))})
+	}
+	c.removeOldest()
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("removed", i)
+		c.add(key, ByteView{str: "v", e: e})
+		c.remove(key)
+	}
+	for i := 0; i < 100; i++ {
+		c.add("replaced", ByteView{str: "v", e: e.Add(time.Duration(i))})
+	}
+	c.add("forever", ByteView{str: "v", e: e})
+	c.add("forever", ByteView{str: "v"})
+
+	if len(c.expiries) != 1 {
+		t.Errorf("expiries holds %d elements for 1 entry with an expiry", len(c.expiries))
+	}
+	c.removeExpired(e.Add(time.Hour))
+	if got, want := c.stats().Items, int64(1); got != want {
+		t.Errorf("items = %d; want %d", got, want)
+	}
+	if _, ok := c.get("forever"); !ok {
+		t.Errorf("entry without an expiry was removed")
+	}
+}
+
+// fakePeer records the requests it receives.
+type fakePeer struct {
+	mu      sync.Mutex
+	gets    int
+	multis  int
+	removes []string
+	sets    map[string]string
+	fills   map[string]string
+	// removeErr is returned by Remove.
+	removeErr error
+}
+
+func (p *fakePeer) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gets++
+	out.Value = []byte("peer-" + in.Key)
+	return nil
+}
+
+func (p *fakePeer) Remove(ctx context.Context, in *pb.RemoveRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removes = append(p.removes, in.Key)
+	return p.removeErr
+}
+
+// GetMulti reports "missing" as not found and fails to load "fail".
+func (p *fakePeer) GetMulti(ctx context.Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.multis++
+	for i, key := range in.Keys {
+		res := &pb.GetResponse{Value: []byte("peer-" + key)}
+		switch key {
+		case "missing":
+			res = &pb.GetResponse{NotFound: true}
+		case "fail":
+			res = &pb.GetResponse{}
+			out.Failed = append(out.Failed, int32(i))
+		}
+		out.Values = append(out.Values, res)
+	}
+	return nil
+}
+
+func (p *fakePeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if in.Fill {
+		if p.fills == nil {
+			p.fills = make(map[string]string)
+		}
+		p.fills[in.Key] = string(in.Value)
+		return nil
+	}
+	if p.sets == nil {
+		p.sets = make(map[string]string)
+	}
+	p.sets[in.Key] = string(in.Value)
+	return nil
+}
+
+// fakePeers owns every key through owner, unless the key is listed in local.
+type fakePeers struct {
+	owner  *fakePeer
+	others []*fakePeer
+	local  map[string]bool
+}
+
+func (p *fakePeers) PickPeer(key string) (ProtoGetter, bool) {
+	if p.local[key] {
+		return nil, false
+	}
+	return p.owner, true
+}
+
+func (p *fakePeers) GetAll() []ProtoGetter {
+	all := []ProtoGetter{p.owner}
+	for _, peer := range p.others {
+		all = append(all, peer)
+	}
+	return all
+}
+
+func TestRemove(t *testing.T) {
+	peers := &fakePeers{
+		owner:  &fakePeer{},
+		others: []*fakePeer{{}, {}},
+		local:  map[string]bool{"local": true},
+	}
+	var loads int
+	g := NewUniverse().NewGroup("remove", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}))
+	g.RegisterPeers(peers)
+
+	if _, err := g.Get("local"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if err := g.Remove("local"); err != nil {
+		t.Fatalf("Remove error = %v", err)
+	}
+	if _, err := g.Get("local"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if loads != 2 {
+		t.Errorf("loads = %d; want 2 after Remove", loads)
+	}
+
+	g.populateCache("remote", ByteView{str: "hot"}, &g.hotCache)
+	if err := g.Remove("remote"); err != nil {
+		t.Fatalf("Remove error = %v", err)
+	}
+	if _, ok := g.lookupCache("remote"); ok {
+		t.Errorf("hot copy of remote survived Remove")
+	}
+	for i, peer := range append([]*fakePeer{peers.owner}, peers.others...) {
+		if got, want := len(peer.removes), 2; got != want {
+			t.Errorf("peer %d got %d removes; want %d", i, got, want)
+		}
+	}
+}
+
+// store is a Getter and Setter backed by a map.
+type store struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (s *store) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.data[key]; ok {
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("%s not exist", key)
+}
+
+func (s *store) Set(ctx context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = string(value)
+	return nil
+}
+
+func TestSet(t *testing.T) {
+	peers := &fakePeers{
+		owner:  &fakePeer{},
+		others: []*fakePeer{{}},
+		local:  map[string]bool{"local": true},
+	}
+	origin := &store{data: map[string]string{"local": "old"}}
+	g := NewUniverse().NewGroup("set", 1<<10, origin)
+	g.RegisterPeers(peers)
+
+	if _, err := g.Get("local"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if err := g.Set("local", []byte("new")); err != nil {
+		t.Fatalf("Set error = %v", err)
+	}
+	if got := origin.data["local"]; got != "new" {
+		t.Errorf("origin has %q; want %q", got, "new")
+	}
+	if view, ok := g.mainCache.get("local"); !ok || view.String() != "new" {
+		t.Errorf("main cache has %q, %v; want %q", view.String(), ok, "new")
+	}
+	for i, peer := range append([]*fakePeer{peers.owner}, peers.others...) {
+		if len(peer.removes) != 1 {
+			t.Errorf("peer %d got %d removes; want 1", i, len(peer.removes))
+		}
+	}
+
+	g.populateCache("remote", ByteView{str: "hot"}, &g.hotCache)
+	if err := g.Set("remote", []byte("new")); err != nil {
+		t.Fatalf("Set error = %v", err)
+	}
+	if got := peers.owner.sets["remote"]; got != "new" {
+		t.Errorf("owner got %q; want %q", got, "new")
+	}
+	if _, ok := g.lookupCache("remote"); ok {
+		t.Errorf("hot copy of remote survived Set")
+	}
+	if _, ok := origin.data["remote"]; ok {
+		t.Errorf("non-owner persisted remote")
+	}
+}
+
+func TestSetPeerRemoveError(t *testing.T) {
+	failed := errors.New("unreachable")
+	peers := &fakePeers{
+		owner:  &fakePeer{},
+		others: []*fakePeer{{removeErr: failed}},
+		local:  map[string]bool{"local": true},
+	}
+	origin := &store{data: map[string]string{}}
+	g := NewUniverse().NewGroup("set-remove-error", 1<<10, origin)
+	g.RegisterPeers(peers)
+
+	err := g.Set("local", []byte("new"))
+	var removeErr *PeerRemoveError
+	if !errors.As(err, &removeErr) || !errors.Is(err, failed) {
+		t.Fatalf("Set error = %v; want a *PeerRemoveError wrapping %v", err, failed)
+	}
+	if got := origin.data["local"]; got != "new" {
+		t.Errorf("origin has %q; want %q", got, "new")
+	}
+	if view, ok := g.mainCache.get("local"); !ok || view.String() != "new" {
+		t.Errorf("main cache has %q, %v; want %q", view.String(), ok, "new")
+	}
+}
+
+func TestLoadBeforeChangeNotCached(t *testing.T) {
+	for _, change := range []string{"Set", "Remove"} {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		g := NewUniverse().NewGroup("load-before-"+change, 1<<10, GetterFunc(func(key string) ([]byte, error) {
+			close(started)
+			<-release
+			return []byte("old"), nil
+		}))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if view, err := g.Get("key"); err != nil || view.String() != "old" {
+				t.Errorf("Get = %q, %v; want %q", view.String(), err, "old")
+			}
+		}()
+		<-started
+		var err error
+		if change == "Set" {
+			err = g.Set("key", []byte("new"))
+		} else {
+			err = g.Remove("key")
+		}
+		if err != nil {
+			t.Fatalf("%s error = %v", change, err)
+		}
+		close(release)
+		<-done
+
+		view, ok := g.lookupCache("key")
+		if change == "Set" && view.String() != "new" {
+			t.Errorf("after %s, cached %q, %v; want %q", change, view.String(), ok, "new")
+		}
+		if change == "Remove" && ok {
+			t.Errorf("after %s, cached %q; want nothing", change, view.String())
+		}
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	var loads int
+	g := NewUniverse().NewGroupOpts("negative", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return nil, &NotFoundError{Key: key}
+	}), &GroupOptions{NegativeTTL: 20 * time.Millisecond})
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get("missing"); !IsNotFound(err) {
+			t.Fatalf("Get error = %v; want a NotFoundError", err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("loads = %d; want 1", loads)
+	}
+	if got := g.Stats.NegativeHits.Get(); got != 2 {
+		t.Errorf("NegativeHits = %d; want 2", got)
+	}
+	stats := g.mainCache.stats()
+	if stats.NegativeItems != 1 || stats.NegativeBytes != int64(len("missing")) {
+		t.Errorf("negative items, bytes = %d, %d; want 1, %d",
+			stats.NegativeItems, stats.NegativeBytes, len("missing"))
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := g.Get("missing"); !IsNotFound(err) {
+		t.Fatalf("Get error = %v; want a NotFoundError", err)
+	}
+	if loads != 2 {
+		t.Errorf("loads = %d after NegativeTTL; want 2", loads)
+	}
+}
+
+func TestNegativeCachingDisabled(t *testing.T) {
+	var loads int
+	g := NewUniverse().NewGroup("negative-disabled", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return nil, fmt.Errorf("lookup %s: %w", key, &NotFoundError{Key: key})
+	}))
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get("missing"); !IsNotFound(err) {
+			t.Fatalf("Get error = %v; want a wrapped NotFoundError", err)
+		}
+	}
+	if loads != 2 {
+		t.Errorf("loads = %d; want 2", loads)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var mu sync.Mutex
+	version := 0
+	release := make(chan struct{}, 1)
+	g := NewUniverse().NewGroupOpts("stale", 1<<10, ExpiringGetterFunc(
+		func(ctx context.Context, key string) ([]byte, time.Time, error) {
+			mu.Lock()
+			refresh := version > 0
+			mu.Unlock()
+			if refresh {
+				<-release
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			version++
+			return []byte(fmt.Sprint(version)), time.Now().Add(20 * time.Millisecond), nil
+		}), &GroupOptions{StaleWhileRevalidate: time.Hour})
+
+	if view, _ := g.Get("key"); view.String() != "1" {
+		t.Fatalf("Get = %q; want %q", view.String(), "1")
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// the refresh is blocked, so every Get must return the stale value
+	for i := 0; i < 3; i++ {
+		view, err := g.Get("key")
+		if err != nil || view.String() != "1" {
+			t.Fatalf("Get = %q, %v; want stale %q", view.String(), err, "1")
+		}
+	}
+	if got := g.Stats.StaleServes.Get(); got != 3 {
+		t.Errorf("StaleServes = %d; want 3", got)
+	}
+	if got := g.Stats.Refreshes.Get(); got != 1 {
+		t.Errorf("Refreshes = %d; want 1", got)
+	}
+
+	release <- struct{}{}
+	deadline := time.Now().Add(time.Second)
+	for {
+		view, _ := g.Get("key")
+		if view.String() == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Get = %q after refresh; want %q", view.String(), "2")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStaleWhileRevalidateHardExpiry(t *testing.T) {
+	var loads int
+	g := NewUniverse().NewGroupOpts("stale-hard", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(fmt.Sprint(loads)), nil
+	}), &GroupOptions{TTL: 10 * time.Millisecond, StaleWhileRevalidate: 10 * time.Millisecond})
+
+	g.Get("key")
+	time.Sleep(30 * time.Millisecond)
+	if view, _ := g.Get("key"); view.String() != "2" {
+		t.Errorf("Get past hard expiry = %q; want %q", view.String(), "2")
+	}
+	if got := g.Stats.StaleServes.Get(); got != 0 {
+		t.Errorf("StaleServes = %d; want 0", got)
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	peers := &fakePeers{
+		owner: &fakePeer{},
+		local: map[string]bool{"local": true, "cached": true},
+	}
+	var mu sync.Mutex
+	var loaded []string
+	g := NewUniverse().NewGroup("get-multi", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		loaded = append(loaded, key)
+		return []byte("local-" + key), nil
+	}))
+	g.RegisterPeers(peers)
+	if _, err := g.Get("cached"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+
+	got, err := g.GetMulti([]string{"cached", "local", "a", "b", "missing", "fail"})
+	if err != nil {
+		t.Fatalf("GetMulti error = %v", err)
+	}
+	want := map[string]string{
+		"cached": "local-cached",
+		"local":  "local-local",
+		"a":      "peer-a",
+		"b":      "peer-b",
+		"fail":   "local-fail",
+	}
+	if len(got) != len(want) {
+		t.Errorf("GetMulti returned %d values; want %d", len(got), len(want))
+	}
+	for key, value := range want {
+		if got[key].String() != value {
+			t.Errorf("GetMulti[%q] = %q; want %q", key, got[key].String(), value)
+		}
+	}
+	if peers.owner.multis != 1 || peers.owner.gets != 0 {
+		t.Errorf("owner got %d batch and %d single requests; want 1 and 0",
+			peers.owner.multis, peers.owner.gets)
+	}
+	if len(loaded) != 3 {
+		t.Errorf("getter loaded %v; want cached, local and fail", loaded)
+	}
+	// 与 Get 一样，每个未命中缓存的 key 计一次加载
+	if got := g.Stats.Loads.Get(); got != 6 {
+		t.Errorf("Loads = %d; want 6, one for each key missing the cache", got)
+	}
+	if got := g.Stats.LoadsDeduped.Get(); got != 6 {
+		t.Errorf("LoadsDeduped = %d; want 6", got)
+	}
+}
+
+// delayedPeer is a fakePeer whose Get and GetMulti take delay.
+type delayedPeer struct {
+	fakePeer
+	delay time.Duration
+}
+
+func (p *delayedPeer) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	time.Sleep(p.delay)
+	return p.fakePeer.Get(ctx, in, out)
+}
+
+func (p *delayedPeer) GetMulti(ctx context.Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error {
+	time.Sleep(p.delay)
+	return p.fakePeer.GetMulti(ctx, in, out)
+}
+
+func TestGetMultiSharesLoadsWithGet(t *testing.T) {
+	peer := &delayedPeer{delay: 30 * time.Millisecond}
+	g := NewUniverse().NewGroup("get-multi-shared", 1<<10, countingGetter(new(AtomicInt)))
+	g.RegisterPeers(replicaPeers{peer})
+
+	// a Get of a key the batch is loading waits for the batch
+	multi := make(chan map[string]ByteView)
+	go func() {
+		got, _ := g.GetMulti([]string{"a", "b"})
+		multi <- got
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if view, err := g.Get("a"); err != nil || view.String() != "peer-a" {
+		t.Errorf("Get = %q, %v; want %q", view.String(), err, "peer-a")
+	}
+	if got := <-multi; len(got) != 2 {
+		t.Errorf("GetMulti returned %d values; want 2", len(got))
+	}
+
+	// a batch waits for the Get of a key already being loaded
+	get := make(chan ByteView)
+	go func() {
+		view, _ := g.Get("c")
+		get <- view
+	}()
+	time.Sleep(10 * time.Millisecond)
+	got, err := g.GetMulti([]string{"c", "d"})
+	if err != nil || got["c"].String() != "peer-c" || got["d"].String() != "peer-d" {
+		t.Errorf("GetMulti = %v, %v; want peer-c and peer-d", got, err)
+	}
+	<-get
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.gets != 1 || peer.multis != 2 {
+		t.Errorf("peer got %d single and %d batch requests; want 1 and 2", peer.gets, peer.multis)
+	}
+}
+
+func TestGetMultiAbandoned(t *testing.T) {
+	g := NewUniverse().NewGroup("get-multi-abandoned", 1<<10, countingGetter(new(AtomicInt)))
+	g.RegisterPeers(replicaPeers{&delayedPeer{delay: 30 * time.Millisecond}})
+
+	// the caller of the batch leaves, a Get waiting for one of its keys
+	// loads the key itself
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	multi := make(chan error)
+	go func() {
+		_, err := g.GetMultiContext(ctx, []string{"a"})
+		multi <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	if view, err := g.Get("a"); err != nil || view.String() != "peer-a" {
+		t.Errorf("Get = %q, %v; want %q", view.String(), err, "peer-a")
+	}
+	if err := <-multi; err != context.DeadlineExceeded {
+		t.Errorf("GetMultiContext error = %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestGetMultiCostPerKey(t *testing.T) {
+	g := NewUniverse().NewGroup("get-multi-cost", 1<<10, countingGetter(new(AtomicInt)))
+	g.RegisterPeers(replicaPeers{&delayedPeer{delay: 40 * time.Millisecond}})
+
+	keys := []string{"a", "b", "c", "d"}
+	start := time.Now()
+	views, errs := g.getMulti(context.Background(), keys)
+	share := time.Since(start) / time.Duration(len(keys))
+	for i, key := range keys {
+		if errs[i] != nil {
+			t.Fatalf("getMulti(%q) error = %v", key, errs[i])
+		}
+		// each key is charged a quarter of the batch
+		if cost := views[i].cost; cost < 10*time.Millisecond || cost > share {
+			t.Errorf("cost of %q = %v; want between 10ms and %v, a quarter of the batch", key, cost, share)
+		}
+	}
+}
+
+// flakyPeer fails the first failures Gets, then serves like fakePeer.
+type flakyPeer struct {
+	fakePeer
+	failures int
+}
+
+func (p *flakyPeer) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	p.mu.Lock()
+	if p.failures > 0 {
+		p.failures--
+		p.gets++
+		p.mu.Unlock()
+		return errors.New("connection refused")
+	}
+	p.mu.Unlock()
+	return p.fakePeer.Get(ctx, in, out)
+}
+
+// replicaPeers is a ReplicaPicker over a fixed list of replicas,
+// nil standing for the current peer.
+type replicaPeers []ProtoGetter
+
+func (p replicaPeers) PickPeer(key string) (ProtoGetter, bool) {
+	return p[0], p[0] != nil
+}
+
+func (p replicaPeers) GetAll() []ProtoGetter {
+	return nil
+}
+
+func (p replicaPeers) Replication() int {
+	return len(p)
+}
+
+func (p replicaPeers) PickPeers(key string, n int) []ProtoGetter {
+	if n > len(p) {
+		n = len(p)
+	}
+	return p[:n]
+}
+
+// countingGetter returns a getter counting its calls in loads.
+func countingGetter(loads *AtomicInt) Getter {
+	return GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte("local-" + key), nil
+	})
+}
+
+func TestPeerRetries(t *testing.T) {
+	var loads AtomicInt
+	peer := &flakyPeer{failures: 2}
+	g := NewUniverse().NewGroupOpts("peer-retries", 1<<10, countingGetter(&loads),
+		&GroupOptions{PeerRetries: 2, PeerBackoff: time.Millisecond})
+	g.RegisterPeers(replicaPeers{peer})
+
+	view, err := g.Get("key")
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if view.String() != "peer-key" {
+		t.Errorf("Get = %q; want %q", view.String(), "peer-key")
+	}
+	if loads.Get() != 0 {
+		t.Errorf("getter called %d times; want 0", loads.Get())
+	}
+	if got := g.Stats.PeerRetries.Get(); got != 2 {
+		t.Errorf("PeerRetries = %d; want 2", got)
+	}
+	if got := g.Stats.PeerErrors.Get(); got != 2 {
+		t.Errorf("PeerErrors = %d; want 2", got)
+	}
+}
+
+func TestPeerTimeout(t *testing.T) {
+	var loads AtomicInt
+	g := NewUniverse().NewGroupOpts("peer-timeout", 1<<10, countingGetter(&loads),
+		&GroupOptions{PeerTimeout: 10 * time.Millisecond})
+	g.RegisterPeers(blockingPeers{})
+
+	view, err := g.Get("key")
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if view.String() != "local-key" {
+		t.Errorf("Get = %q; want %q", view.String(), "local-key")
+	}
+	if got := g.Stats.PeerTimeouts.Get(); got != 1 {
+		t.Errorf("PeerTimeouts = %d; want 1", got)
+	}
+	if got := g.Stats.FallbackLocals.Get(); got != 1 {
+		t.Errorf("FallbackLocals = %d; want 1", got)
+	}
+}
+
+func TestPeerFallbackError(t *testing.T) {
+	var loads AtomicInt
+	g := NewUniverse().NewGroupOpts("peer-fallback-error", 1<<10, countingGetter(&loads),
+		&GroupOptions{PeerFallback: FallbackError})
+	g.RegisterPeers(replicaPeers{&flakyPeer{failures: 1}})
+
+	if _, err := g.Get("key"); err == nil {
+		t.Fatalf("Get error = nil; want the peer's error")
+	}
+	if loads.Get() != 0 {
+		t.Errorf("getter called %d times; want 0", loads.Get())
+	}
+	if got := g.Stats.FallbackErrors.Get(); got != 1 {
+		t.Errorf("FallbackErrors = %d; want 1", got)
+	}
+}
+
+func TestPeerFallbackNextReplica(t *testing.T) {
+	var loads AtomicInt
+	next := &fakePeer{}
+	g := NewUniverse().NewGroupOpts("peer-fallback-replica", 1<<10, countingGetter(&loads),
+		&GroupOptions{PeerFallback: FallbackNextReplica})
+	g.RegisterPeers(replicaPeers{&flakyPeer{failures: 1}, next})
+
+	view, err := g.Get("key")
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if view.String() != "peer-key" || next.gets != 1 {
+		t.Errorf("Get = %q with %d gets on the next replica; want %q with 1", view.String(), next.gets, "peer-key")
+	}
+	if got := g.Stats.FallbackPeers.Get(); got != 1 {
+		t.Errorf("FallbackPeers = %d; want 1", got)
+	}
+
+	// the next replica is this peer
+	g = NewUniverse().NewGroupOpts("peer-fallback-replica-self", 1<<10, countingGetter(&loads),
+		&GroupOptions{PeerFallback: FallbackNextReplica})
+	g.RegisterPeers(replicaPeers{&flakyPeer{failures: 1}, nil})
+	if view, err := g.Get("key"); err != nil || view.String() != "local-key" {
+		t.Errorf("Get = %q, %v; want %q", view.String(), err, "local-key")
+	}
+	if got := g.Stats.FallbackLocals.Get(); got != 1 {
+		t.Errorf("FallbackLocals = %d; want 1", got)
+	}
+}
+
+func TestPeerRequestNotForwarded(t *testing.T) {
+	var loads AtomicInt
+	peer := &fakePeer{}
+	g := NewUniverse().NewGroup("peer-request", 1<<10, countingGetter(&loads))
+	g.RegisterPeers(replicaPeers{peer})
+
+	view, err := g.GetContext(withPeerRequest(context.Background()), "key")
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if view.String() != "local-key" || peer.gets != 0 {
+		t.Errorf("Get = %q with %d peer gets; want %q loaded locally", view.String(), peer.gets, "local-key")
+	}
+}
+
+func TestReplicaFallback(t *testing.T) {
+	var loads AtomicInt
+	third := &fakePeer{}
+	g := NewUniverse().NewGroupOpts("replica-fallback", 1<<10, countingGetter(&loads),
+		&GroupOptions{PeerFallback: FallbackError})
+	g.RegisterPeers(replicaPeers{&flakyPeer{failures: 1}, &flakyPeer{failures: 1}, third})
+
+	view, err := g.Get("key")
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if view.String() != "peer-key" || third.gets != 1 {
+		t.Errorf("Get = %q with %d gets on the third replica; want %q with 1", view.String(), third.gets, "peer-key")
+	}
+	if got := g.Stats.FallbackPeers.Get(); got != 2 {
+		t.Errorf("FallbackPeers = %d; want 2", got)
+	}
+	if loads.Get() != 0 {
+		t.Errorf("getter called %d times; want 0", loads.Get())
+	}
+}
+
+func TestReplicaFills(t *testing.T) {
+	var loads AtomicInt
+	replicas := []*fakePeer{{}, {}}
+	g := NewUniverse().NewGroupOpts("replica-fills", 1<<10, countingGetter(&loads),
+		&GroupOptions{ReplicaFills: true})
+	g.RegisterPeers(replicaPeers{nil, replicas[0], replicas[1]})
+
+	if _, err := g.Get("key"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for g.Stats.ReplicaFills.Get() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for i, peer := range replicas {
+		peer.mu.Lock()
+		if got := peer.fills["key"]; got != "local-key" {
+			t.Errorf("replica %d filled with %q; want %q", i, got, "local-key")
+		}
+		peer.mu.Unlock()
+	}
+
+	// only a replica of the key pushes it
+	other := &fakePeer{}
+	g = NewUniverse().NewGroupOpts("replica-fills-other", 1<<10, countingGetter(&loads),
+		&GroupOptions{ReplicaFills: true})
+	g.RegisterPeers(replicaPeers{other})
+	g.peersOnce.Do(g.initPeers)
+	g.fillReplicas("key", ByteView{str: "value"})
+	time.Sleep(10 * time.Millisecond)
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	if len(other.fills) != 0 {
+		t.Errorf("a peer that is not a replica pushed %v", other.fills)
+	}
+}
+
+// hungPeer is a fakePeer whose Set never answers.
+type hungPeer struct{ fakePeer }
+
+func (p *hungPeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestReplicaFillsHungReplica(t *testing.T) {
+	var loads AtomicInt
+	g := NewUniverse().NewGroupOpts("replica-fills-hung", 1<<10, countingGetter(&loads),
+		&GroupOptions{ReplicaFills: true, PeerTimeout: 20 * time.Millisecond})
+	g.RegisterPeers(replicaPeers{nil, &hungPeer{}})
+	g.peersOnce.Do(g.initPeers)
+
+	for i := 0; i < maxReplicaFills+10; i++ {
+		g.fillReplicas("key", ByteView{str: "value"})
+	}
+	if got := g.Stats.ReplicaFillDrops.Get(); got != 10 {
+		t.Errorf("ReplicaFillDrops = %d; want 10", got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(g.fills) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := g.Stats.ReplicaFillErrs.Get(); got != maxReplicaFills {
+		t.Errorf("ReplicaFillErrs = %d; want the %d pushes timed out", got, maxReplicaFills)
+	}
+	// the pushes that timed out make room for new ones
+	g.fillReplicas("key", ByteView{str: "value"})
+	if got := g.Stats.ReplicaFillDrops.Get(); got != 10 {
+		t.Errorf("ReplicaFillDrops = %d after the pushes timed out; want 10", got)
+	}
+}
+
+func TestReplicaFillsSetDuringLoad(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	replica := &fakePeer{}
+	g := NewUniverse().NewGroupOpts("replica-fills-set", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("old"), nil
+	}), &GroupOptions{ReplicaFills: true})
+	g.RegisterPeers(replicaPeers{nil, replica})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Get("key")
+	}()
+	<-started
+	if err := g.Set("key", []byte("new")); err != nil {
+		t.Fatalf("Set error = %v", err)
+	}
+	close(release)
+	<-done
+	time.Sleep(10 * time.Millisecond)
+
+	replica.mu.Lock()
+	defer replica.mu.Unlock()
+	if got, ok := replica.fills["key"]; ok {
+		t.Errorf("replica filled with %q loaded before the Set", got)
+	}
+	if got := g.Stats.ReplicaFills.Get(); got != 0 {
+		t.Errorf("ReplicaFills = %d; want 0", got)
+	}
+}
+
+func TestLocalFillAfterInvalidate(t *testing.T) {
+	g := NewUniverse().NewGroup("local-fill-invalidated", 1<<10, countingGetter(new(AtomicInt)))
+
+	// 推送在 Remove 之前发出、之后到达
+	if err := g.Remove("key"); err != nil {
+		t.Fatalf("Remove error = %v", err)
+	}
+	if err := g.localFill("key", []byte("old"), 0); err != nil {
+		t.Fatalf("localFill error = %v", err)
+	}
+	if view, ok := g.lookupCache("key"); ok {
+		t.Errorf("cached %q pushed before the Remove", view.String())
+	}
+
+	if err := g.localFill("other", []byte("value"), 0); err != nil {
+		t.Fatalf("localFill error = %v", err)
+	}
+	if view, ok := g.lookupCache("other"); !ok || view.String() != "value" {
+		t.Errorf("cached %q, %v; want %q", view.String(), ok, "value")
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	var loads AtomicInt
+	g := NewUniverse().NewGroupOpts("max-value-size", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key), nil
+	}), &GroupOptions{MaxValueSize: 8, PeerRetries: 2})
+
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get("too-large-key"); err != ErrValueTooLarge {
+			t.Fatalf("Get error = %v; want %v", err, ErrValueTooLarge)
+		}
+	}
+	if got := loads.Get(); got != 2 {
+		t.Errorf("getter called %d times; want 2, too large values are not cached", got)
+	}
+	if view, err := g.Get("small"); err != nil || view.String() != "small" {
+		t.Errorf("Get = %q, %v; want %q", view.String(), err, "small")
+	}
+	if err := g.Set("key", []byte("too large value")); err != ErrValueTooLarge {
+		t.Errorf("Set error = %v; want %v", err, ErrValueTooLarge)
+	}
+
+	// a too large value from a peer is neither retried nor loaded locally
+	peer := &fakePeer{}
+	g = NewUniverse().NewGroupOpts("max-value-size", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(key), nil
+	}), &GroupOptions{MaxValueSize: 8, PeerRetries: 2})
+	g.RegisterPeers(&fakePeers{owner: peer})
+	if _, err := g.Get("remote"); err != ErrValueTooLarge {
+		t.Errorf("Get from peer error = %v; want %v", err, ErrValueTooLarge)
+	}
+	if peer.gets != 1 || loads.Get() != 3 {
+		t.Errorf("peer gets = %d, getter calls = %d; want 1 and 3", peer.gets, loads.Get())
+	}
+}
+
+func TestLoadLinger(t *testing.T) {
+	var loads AtomicInt
+	g := NewUniverse().NewGroupOpts("load-linger", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return nil, errors.New("origin down")
+	}), &GroupOptions{LoadLinger: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get("key"); err == nil {
+			t.Fatalf("Get succeeded; want the origin error")
+		}
+	}
+	if got := loads.Get(); got != 1 {
+		t.Errorf("getter called %d times; want 1 while the error lingers", got)
+	}
+	if got := groupStats(g).LingeringLoads; got != 1 {
+		t.Errorf("LingeringLoads = %d; want 1", got)
+	}
+
+	// Remove 之后不再使用保留的结果
+	if err := g.Remove("key"); err != nil {
+		t.Fatalf("Remove error = %v", err)
+	}
+	g.Get("key")
+	if got := loads.Get(); got != 2 {
+		t.Errorf("getter called %d times; want 2 after Remove", got)
+	}
+}
+
+func TestLoadLingerTTL(t *testing.T) {
+	g := NewUniverse().NewGroupOpts("load-linger-ttl", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), &GroupOptions{LoadLinger: time.Hour, TTL: time.Minute})
+	if g.loadGroup.Linger != time.Minute {
+		t.Errorf("Linger = %v; want it cut down to the TTL, %v", g.loadGroup.Linger, time.Minute)
+	}
+}
+
+func TestLoadLingerRefresh(t *testing.T) {
+	var version AtomicInt
+	g := NewUniverse().NewGroupOpts("load-linger-refresh", 1<<10, ExpiringGetterFunc(
+		func(ctx context.Context, key string) ([]byte, time.Time, error) {
+			version.Add(1)
+			return []byte(fmt.Sprint(version.Get())), time.Now().Add(20 * time.Millisecond), nil
+		}), &GroupOptions{LoadLinger: time.Hour, StaleWhileRevalidate: time.Hour})
+
+	if view, _ := g.Get("key"); view.String() != "1" {
+		t.Fatalf("Get = %q; want %q", view.String(), "1")
+	}
+	time.Sleep(30 * time.Millisecond)
+	// the stale value triggers a refresh, which must not get the result
+	// of the first load, still lingering
+	g.Get("key")
+	deadline := time.Now().Add(time.Second)
+	for {
+		if view, _ := g.lookupCache("key"); view.String() == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			view, _ := g.lookupCache("key")
+			t.Fatalf("cached %q after refresh; want %q", view.String(), "2")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetterPanic(t *testing.T) {
+	done := make(chan struct{})
+	g := NewUniverse().NewGroup("getter-panic", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "late" {
+			defer close(done)
+			time.Sleep(20 * time.Millisecond)
+		}
+		panic("getter bug")
+	}))
+
+	if _, err := g.Get("key"); err == nil || !strings.Contains(err.Error(), "getter bug") {
+		t.Errorf("Get error = %v; want the panic as an error", err)
+	}
+	// a panic once every caller has gone away must not crash the program
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := g.GetContext(ctx, "late"); err != context.DeadlineExceeded {
+		t.Errorf("GetContext error = %v; want %v", err, context.DeadlineExceeded)
+	}
+	<-done
+	time.Sleep(10 * time.Millisecond)
+}
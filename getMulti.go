@@ -0,0 +1,229 @@
+package dailzCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/dailz1/dailzCache/dailzCachepb"
+	"github.com/dailz1/dailzCache/singleFlight"
+	"sync"
+	"time"
+)
+
+// GetMulti returns the values of the keys, loading the missing ones with
+// one request per owner peer.
+func (g *Group) GetMulti(keys []string) (map[string]ByteView, error) {
+	return g.GetMultiContext(context.Background(), keys)
+}
+
+// GetMultiContext is like GetMulti but gives up loading once ctx is done.
+// Keys that do not exist are left out of the result. If some keys fail
+// to load, the others are still returned along with the first error.
+func (g *Group) GetMultiContext(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	if g.closed() {
+		return nil, ErrGroupClosed
+	}
+	views, errs := g.getMulti(ctx, keys)
+	result := make(map[string]ByteView, len(keys))
+	var firstErr error
+	for i, key := range keys {
+		switch err := errs[i]; {
+		case err == nil:
+			result[key] = views[i]
+		case IsNotFound(err):
+		case firstErr == nil:
+			firstErr = err
+		}
+	}
+	return result, firstErr
+}
+
+// getMulti returns the value or the error of each key, in order.
+func (g *Group) getMulti(ctx context.Context, keys []string) ([]ByteView, []error) {
+	g.peersOnce.Do(g.initPeers)
+	views := make([]ByteView, len(keys))
+	errs := make([]error, len(keys))
+
+	// 缓存未命中的 key 按 owner 分组，每个 peer 只发送一次请求
+	batches := make(map[ProtoGetter][]int)
+	var local []int
+	for i, key := range keys {
+		g.Stats.Gets.Add(1)
+		if value, cacheHit := g.lookupCache(key); cacheHit {
+			views[i], errs[i] = g.cacheHit(key, value)
+			continue
+		}
+		if peer, ok := g.peers.PickPeer(key); ok && !isPeerRequest(ctx) {
+			batches[peer] = append(batches[peer], i)
+		} else {
+			local = append(local, i)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for peer, batch := range batches {
+		wg.Add(1)
+		go func(peer ProtoGetter, batch []int) {
+			defer wg.Done()
+			g.getMultiFromPeer(ctx, peer, keys, batch, views, errs)
+		}(peer, batch)
+	}
+	for _, i := range local {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			views[i], errs[i] = g.load(ctx, keys[i])
+		}(i)
+	}
+	wg.Wait()
+	return views, errs
+}
+
+// getMultiFromPeer loads keys[i] for each i of batch from the peer.
+// The keys go through loadGroup like those of Get: a key already being
+// loaded is waited for, and a Get of a key the batch loads waits for it.
+func (g *Group) getMultiFromPeer(ctx context.Context, peer ProtoGetter, keys []string, batch []int,
+	views []ByteView, errs []error) {
+	type loaded struct {
+		value ByteView
+		err   error
+	}
+	results := make([]<-chan singleFlight.Result[ByteView], len(batch))
+	slots := make([]chan loaded, len(batch))
+	var lead []string
+	var leadSlots []chan loaded
+	for j, i := range batch {
+		g.Stats.Loads.Add(1)
+		key, slot := keys[i], make(chan loaded, 1)
+		ch, started := g.loadGroup.DoChanLead(ctx, key, func(ctx context.Context) (ByteView, error) {
+			select {
+			case r := <-slot:
+				if r.err == errBatchAbandoned {
+					// 发起批量请求的调用方已经离开，仍在等待的调用方自己加载
+					return g.doLoad(ctx, key)
+				}
+				return r.value, r.err
+			case <-ctx.Done():
+				return ByteView{}, ctx.Err()
+			}
+		})
+		results[j], slots[j] = ch, slot
+		if started {
+			g.Stats.LoadsDeduped.Add(1)
+			lead = append(lead, key)
+			leadSlots = append(leadSlots, slot)
+		}
+	}
+
+	if len(lead) > 0 {
+		leadViews, leadErrs := g.loadBatch(ctx, peer, lead)
+		for j, slot := range leadSlots {
+			err := leadErrs[j]
+			if err != nil && ctx.Err() != nil {
+				err = errBatchAbandoned
+			}
+			slot <- loaded{leadViews[j], err}
+		}
+	}
+
+	for j, i := range batch {
+		select {
+		case r := <-results[j]:
+			views[i], errs[i] = r.Val, r.Err
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+		}
+	}
+}
+
+// loadBatch loads the keys from the peer with one request, retrying as
+// configured, and falls back as PeerFallback says for those the peer
+// fails to load.
+func (g *Group) loadBatch(ctx context.Context, peer ProtoGetter, keys []string) ([]ByteView, []error) {
+	views := make([]ByteView, len(keys))
+	errs := make([]error, len(keys))
+	req := &pb.GetMultiRequest{
+		Group: g.name,
+		Keys:  keys,
+	}
+	if g.opts.MaxValueSize > 0 {
+		ctx = withMaxValueSize(ctx, g.opts.MaxValueSize)
+	}
+	loadCtx := make([]context.Context, len(keys))
+	for j, key := range keys {
+		loadCtx[j] = g.startLoad(ctx, key)
+	}
+	var res *pb.GetMultiResponse
+	start := time.Now()
+	peerErr := g.retryPeer(ctx, func(ctx context.Context) error {
+		res = &pb.GetMultiResponse{}
+		if err := peer.GetMulti(ctx, req, res); err != nil {
+			return err
+		}
+		if len(res.Values) != len(keys) {
+			return fmt.Errorf("peer returned %d values for %d keys", len(res.Values), len(keys))
+		}
+		return nil
+	})
+
+	// 一次请求加载了整批 key，每个 key 只分摊其中的一份耗时
+	cost := time.Since(start) / time.Duration(len(keys))
+
+	var fallback []int
+	if peerErr != nil {
+		for j := range keys {
+			fallback = append(fallback, j)
+		}
+	} else {
+		peerErr = errPeerLoad
+		failed := make(map[int32]bool, len(res.Failed))
+		for _, j := range res.Failed {
+			failed[j] = true
+		}
+		for j, key := range keys {
+			if failed[int32(j)] {
+				fallback = append(fallback, j)
+				continue
+			}
+			var err error
+			if res.Values[j].GetNotFound() {
+				err = &NotFoundError{Key: key}
+			}
+			g.Stats.PeerLoads.Add(1)
+			views[j], errs[j] = g.fromPeer(loadCtx[j], key, res.Values[j], err, cost)
+		}
+	}
+
+	for _, j := range fallback {
+		if err := ctx.Err(); err != nil {
+			errs[j] = err
+			continue
+		}
+		views[j], errs[j] = g.fallback(loadCtx[j], keys[j], peerErr, false)
+	}
+	return views, errs
+}
+
+// errPeerLoad is the error falling back for a key the peer failed to load.
+var errPeerLoad = errors.New("dailzCache: peer failed to load the key")
+
+// errBatchAbandoned tells the load of a key of a batch that the caller
+// sending the batch has gone away before the key was loaded.
+var errBatchAbandoned = errors.New("dailzCache: batch abandoned")
+
+// getMultiResponse encodes the result of getMulti for a peer.
+func getMultiResponse(views []ByteView, errs []error) *pb.GetMultiResponse {
+	res := &pb.GetMultiResponse{Values: make([]*pb.GetResponse, len(views))}
+	for i, view := range views {
+		switch err := errs[i]; {
+		case err == nil:
+			res.Values[i] = getResponse(view)
+		case IsNotFound(err):
+			res.Values[i] = &pb.GetResponse{NotFound: true}
+		default:
+			res.Values[i] = &pb.GetResponse{}
+			res.Failed = append(res.Failed, int32(i))
+		}
+	}
+	return res
+}
@@ -1,4 +1,4 @@
-module dailzCache
+module github.com/dailz1/dailzCache
 
 go 1.19
 
@@ -0,0 +1,138 @@
+package greedyDual
+
+import "container/heap"
+
+// EvictCallback is used to get a callback when a cache entry is evicted
+// or removed.
+type EvictCallback[K comparable, V any] func(key K, value V)
+
+// Cache implements a non-thread safe GreedyDual-Size cache without a
+// fixed size, the caller evicts entries with Evict.
+//
+// Each entry has a priority of L + cost/size, where L is the priority
+// of the last evicted entry. Evict removes the entry with the lowest
+// priority and a hit restores the priority of the entry, so small,
+// expensive and recently used entries are kept the longest, and entries
+// that are no longer used age as L grows.
+type Cache[K comparable, V any] struct {
+	inflation float64 // L, the priority of the last evicted entry
+	entries   map[K]*entry[K, V]
+	queue     priorityQueue[K, V]
+	onEvict   EvictCallback[K, V]
+}
+
+type entry[K comparable, V any] struct {
+	key      K
+	value    V
+	credit   float64 // cost/size
+	priority float64
+	index    int // index in the queue
+}
+
+// New creates an empty GreedyDual-Size cache.
+func New[K comparable, V any](onEvict EvictCallback[K, V]) *Cache[K, V] {
+	return &Cache[K, V]{
+		entries: make(map[K]*entry[K, V]),
+		onEvict: onEvict,
+	}
+}
+
+// Add adds a value to the cache, cost is how expensive the value is
+// to load again and size how much room it takes. Both should be
+// positive, other values are treated as 1.
+func (c *Cache[K, V]) Add(key K, value V, cost float64, size int) {
+	if cost <= 0 {
+		cost = 1
+	}
+	if size <= 0 {
+		size = 1
+	}
+	if e, ok := c.entries[key]; ok {
+		e.value = value
+		e.credit = cost / float64(size)
+		e.priority = c.inflation + e.credit
+		heap.Fix(&c.queue, e.index)
+		return
+	}
+	e := &entry[K, V]{key: key, value: value, credit: cost / float64(size)}
+	e.priority = c.inflation + e.credit
+	c.entries[key] = e
+	heap.Push(&c.queue, e)
+}
+
+// Get looks up a key's value from the cache and restores its priority.
+func (c *Cache[K, V]) Get(key K) (value V, ok bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return value, false
+	}
+	e.priority = c.inflation + e.credit
+	heap.Fix(&c.queue, e.index)
+	return e.value, true
+}
+
+// Peek returns the key's value without updating its priority.
+func (c *Cache[K, V]) Peek(key K) (value V, ok bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return value, false
+	}
+	return e.value, true
+}
+
+// Remove removes the provided key from the cache.
+func (c *Cache[K, V]) Remove(key K) {
+	if e, ok := c.entries[key]; ok {
+		heap.Remove(&c.queue, e.index)
+		c.removeEntry(e)
+	}
+}
+
+// Evict removes the entry with the lowest priority, and raises L to it.
+func (c *Cache[K, V]) Evict() {
+	if len(c.queue) == 0 {
+		return
+	}
+	e := heap.Pop(&c.queue).(*entry[K, V])
+	c.inflation = e.priority
+	c.removeEntry(e)
+}
+
+// Len returns the number of items in the cache.
+func (c *Cache[K, V]) Len() int {
+	return len(c.queue)
+}
+
+func (c *Cache[K, V]) removeEntry(e *entry[K, V]) {
+	delete(c.entries, e.key)
+	if c.onEvict != nil {
+		c.onEvict(e.key, e.value)
+	}
+}
+
+// priorityQueue is a min-heap of entries by priority.
+type priorityQueue[K comparable, V any] []*entry[K, V]
+
+func (q priorityQueue[K, V]) Len() int           { return len(q) }
+func (q priorityQueue[K, V]) Less(i, j int) bool { return q[i].priority < q[j].priority }
+
+func (q priorityQueue[K, V]) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *priorityQueue[K, V]) Push(x any) {
+	e := x.(*entry[K, V])
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *priorityQueue[K, V]) Pop() any {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return e
+}
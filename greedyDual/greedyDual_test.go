@@ -0,0 +1,77 @@
+package greedyDual
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEvictCheapAndLarge(t *testing.T) {
+	var evicted []string
+	c := New[string, int](func(key string, value int) {
+		evicted = append(evicted, key)
+	})
+	c.Add("expensive", 1, 100, 10) // priority 10
+	c.Add("cheap", 2, 1, 10)       // priority 0.1
+	c.Add("large", 3, 100, 1000)   // priority 0.1
+
+	c.Evict()
+	c.Evict()
+	if fmt.Sprint(evicted) != "[cheap large]" && fmt.Sprint(evicted) != "[large cheap]" {
+		t.Fatalf("evicted %v; want cheap and large first", evicted)
+	}
+	if v, ok := c.Get("expensive"); !ok || v != 1 {
+		t.Errorf("Get expensive = %v, %v; want 1, true", v, ok)
+	}
+}
+
+func TestAging(t *testing.T) {
+	c := New[string, int](nil)
+	c.Add("old", 0, 10, 1) // priority 10
+	for i := 0; i < 20; i++ {
+		// each eviction raises L by 1, so new entries outrank old ones
+		key := fmt.Sprint(i)
+		c.Add(key, i, 1, 1)
+		c.Evict()
+	}
+	if _, ok := c.Peek("old"); ok {
+		t.Errorf("unused expensive entry was never evicted")
+	}
+
+	c = New[string, int](nil)
+	c.Add("used", 0, 10, 1)
+	for i := 0; i < 20; i++ {
+		c.Get("used")
+		c.Add(fmt.Sprint(i), i, 1, 1)
+		c.Evict()
+	}
+	if _, ok := c.Peek("used"); !ok {
+		t.Errorf("entry restored by every Get was evicted")
+	}
+}
+
+func TestAddReplacesAndRemove(t *testing.T) {
+	var evicted []string
+	c := New[string, int](func(key string, value int) {
+		evicted = append(evicted, key)
+	})
+	c.Add("a", 1, 1, 1)
+	c.Add("b", 2, 5, 1)
+	c.Add("a", 3, 10, 1)
+	if c.Len() != 2 {
+		t.Fatalf("Len = %d; want 2", c.Len())
+	}
+	if v, _ := c.Peek("a"); v != 3 {
+		t.Errorf("Peek a = %d; want 3", v)
+	}
+
+	c.Evict()
+	if fmt.Sprint(evicted) != "[b]" {
+		t.Errorf("evicted %v; want [b], now cheaper than a", evicted)
+	}
+	c.Remove("a")
+	c.Remove("missing")
+	if c.Len() != 0 || fmt.Sprint(evicted) != "[b a]" {
+		t.Errorf("Len = %d, evicted %v; want 0, [b a]", c.Len(), evicted)
+	}
+	c.Evict()
+}
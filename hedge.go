@@ -0,0 +1,148 @@
+package dailzCache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// latencySamples is how many recent latencies a percentile is taken over.
+	latencySamples = 128
+	// minLatencySamples is how many latencies are needed for a percentile.
+	minLatencySamples = 16
+)
+
+// latencies records the most recent latencies of loads from owners.
+type latencies struct {
+	mu      sync.Mutex
+	samples [latencySamples]time.Duration
+	n       int // number of latencies recorded so far
+}
+
+func (l *latencies) record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples[l.n%latencySamples] = d
+	l.n++
+}
+
+// percentile returns the p-th percentile, 0 < p < 1, of the recorded
+// latencies, or false if there are too few of them.
+func (l *latencies) percentile(p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	n := l.n
+	if n > latencySamples {
+		n = latencySamples
+	}
+	if n < minLatencySamples {
+		l.mu.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, l.samples[:n])
+	l.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p * float64(n))
+	if i >= n {
+		i = n - 1
+	}
+	return sorted[i], true
+}
+
+// hedgeDelay returns how long to wait for the owner before hedging,
+// and false if requests are not hedged.
+func (g *Group) hedgeDelay() (time.Duration, bool) {
+	if g.opts.HedgePercentile > 0 {
+		if d, ok := g.peerLatency.percentile(g.opts.HedgePercentile); ok {
+			if d < g.opts.HedgeDelay {
+				d = g.opts.HedgeDelay
+			}
+			return d, true
+		}
+	}
+	return g.opts.HedgeDelay, g.opts.HedgeDelay > 0
+}
+
+// hedgedLoad loads key from its owner peer like loadFromPeer. If the
+// owner has not answered after the hedge delay, the key is also loaded
+// from the next candidate on the ring, which may be this peer, and the
+// first success wins. The other load is cancelled.
+func (g *Group) hedgedLoad(ctx context.Context, key string, peer ProtoGetter, stale bool) (ByteView, error) {
+	delay, ok := g.hedgeDelay()
+	picker, isReplicaPicker := g.peers.(ReplicaPicker)
+	if !ok || !isReplicaPicker {
+		return g.timedLoad(ctx, key, peer, stale)
+	}
+	peers := picker.PickPeers(key, 2)
+	if len(peers) < 2 {
+		return g.timedLoad(ctx, key, peer, stale)
+	}
+	next := peers[1]
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // 返回时取消落后的请求
+
+	type result struct {
+		value ByteView
+		err   error
+		hedge bool
+	}
+	results := make(chan result, 2)
+	go func() {
+		value, err := g.timedLoad(ctx, key, peer, stale)
+		results <- result{value, err, false}
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	var first error
+	for {
+		select {
+		case <-timer.C:
+			g.Stats.Hedges.Add(1)
+			pending++
+			go func() {
+				var value ByteView
+				var err error
+				if next == nil {
+					value, err = g.loadLocally(ctx, key)
+				} else {
+					value, err = g.loadFromPeer(ctx, key, next, stale)
+				}
+				results <- result{value, err, true}
+			}()
+		case r := <-results:
+			pending--
+			if final(r.err) {
+				if r.hedge {
+					g.Stats.HedgeWins.Add(1)
+				}
+				return r.value, r.err
+			}
+			if !r.hedge {
+				first = r.err
+			}
+			if pending == 0 {
+				// 两个请求都失败，或者对 owner 的请求在对冲之前就失败了
+				return ByteView{}, first
+			}
+		}
+	}
+}
+
+// timedLoad is loadFromPeer recording how long the owner took to answer.
+// A load cancelled before the owner answered, mostly because the hedge
+// won, records how long it waited as a lower bound, or the slowest
+// answers would be left out of the percentile.
+func (g *Group) timedLoad(ctx context.Context, key string, peer ProtoGetter, stale bool) (ByteView, error) {
+	start := time.Now()
+	value, err := g.loadFromPeer(ctx, key, peer, stale)
+	if final(err) || ctx.Err() != nil {
+		g.peerLatency.record(time.Since(start))
+	}
+	return value, err
+}
@@ -0,0 +1,151 @@
+package dailzCache
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/dailz1/dailzCache/dailzCachepb"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowPeer answers Gets after delay, and closes cancelled when it
+// gives up on a request because ctx is done.
+type slowPeer struct {
+	blockingPeer
+	delay     time.Duration
+	cancelled chan struct{}
+}
+
+func (p *slowPeer) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	select {
+	case <-time.After(p.delay):
+		out.Value = []byte("slow-" + in.Key)
+		return nil
+	case <-ctx.Done():
+		close(p.cancelled)
+		return ctx.Err()
+	}
+}
+
+func TestHedgedRequest(t *testing.T) {
+	var loads AtomicInt
+	owner := &slowPeer{delay: time.Hour, cancelled: make(chan struct{})}
+	next := &fakePeer{}
+	g := NewUniverse().NewGroupOpts("hedge", 1<<10, countingGetter(&loads),
+		&GroupOptions{HedgeDelay: 5 * time.Millisecond})
+	g.RegisterPeers(replicaPeers{owner, next})
+
+	view, err := g.Get("key")
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if view.String() != "peer-key" {
+		t.Errorf("Get = %q; want %q from the next peer", view.String(), "peer-key")
+	}
+	select {
+	case <-owner.cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("request to the slow owner was not cancelled")
+	}
+	if got := g.Stats.Hedges.Get(); got != 1 {
+		t.Errorf("Hedges = %d; want 1", got)
+	}
+	if got := g.Stats.HedgeWins.Get(); got != 1 {
+		t.Errorf("HedgeWins = %d; want 1", got)
+	}
+	if got := g.Stats.PeerErrors.Get(); got != 0 {
+		t.Errorf("PeerErrors = %d; want 0 for the cancelled request", got)
+	}
+}
+
+func TestHedgedRequestToSelf(t *testing.T) {
+	var loads AtomicInt
+	owner := &slowPeer{delay: time.Hour, cancelled: make(chan struct{})}
+	g := NewUniverse().NewGroupOpts("hedge-self", 1<<10, countingGetter(&loads),
+		&GroupOptions{HedgeDelay: 5 * time.Millisecond})
+	g.RegisterPeers(replicaPeers{owner, nil})
+
+	view, err := g.Get("key")
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if view.String() != "local-key" || loads.Get() != 1 {
+		t.Errorf("Get = %q after %d local loads; want %q loaded locally", view.String(), loads.Get(), "local-key")
+	}
+}
+
+func TestHedgeNotNeeded(t *testing.T) {
+	var loads AtomicInt
+	next := &fakePeer{}
+	g := NewUniverse().NewGroupOpts("hedge-not-needed", 1<<10, countingGetter(&loads),
+		&GroupOptions{HedgeDelay: time.Hour})
+	g.RegisterPeers(replicaPeers{&fakePeer{}, next})
+
+	if _, err := g.Get("key"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if got := g.Stats.Hedges.Get(); got != 0 || next.gets != 0 {
+		t.Errorf("Hedges = %d with %d gets on the next peer; want none", got, next.gets)
+	}
+}
+
+func TestHedgePercentile(t *testing.T) {
+	g := NewUniverse().NewGroupOpts("hedge-percentile", 1<<10, countingGetter(new(AtomicInt)),
+		&GroupOptions{HedgeDelay: 20 * time.Millisecond, HedgePercentile: 0.9})
+
+	for i := 1; i < minLatencySamples; i++ {
+		g.peerLatency.record(time.Duration(i) * time.Millisecond)
+	}
+	if d, ok := g.hedgeDelay(); !ok || d != 20*time.Millisecond {
+		t.Errorf("hedgeDelay with few latencies = %v, %v; want HedgeDelay", d, ok)
+	}
+
+	for i := minLatencySamples; i <= 100; i++ {
+		g.peerLatency.record(time.Duration(i) * time.Millisecond)
+	}
+	if d, ok := g.hedgeDelay(); !ok || d != 91*time.Millisecond {
+		t.Errorf("hedgeDelay = %v, %v; want the 90th percentile, 91ms", d, ok)
+	}
+
+	// only the most recent latencies count, never below HedgeDelay
+	for i := 0; i < latencySamples; i++ {
+		g.peerLatency.record(time.Millisecond)
+	}
+	if d, _ := g.hedgeDelay(); d != 20*time.Millisecond {
+		t.Errorf("hedgeDelay = %v; want HedgeDelay", d)
+	}
+}
+
+// slowKeysPeer answers Gets of keys starting with "slow" only once ctx
+// is done, and the others at once.
+type slowKeysPeer struct{ fakePeer }
+
+func (p *slowKeysPeer) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	if strings.HasPrefix(in.Key, "slow") {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return p.fakePeer.Get(ctx, in, out)
+}
+
+func TestHedgePercentileSlowOwner(t *testing.T) {
+	g := NewUniverse().NewGroupOpts("hedge-percentile-slow", 1<<10, countingGetter(new(AtomicInt)),
+		&GroupOptions{HedgeDelay: time.Millisecond, HedgePercentile: 0.5})
+	g.RegisterPeers(replicaPeers{&slowKeysPeer{}, &fakePeer{}})
+	for i := 0; i < minLatencySamples; i++ {
+		g.peerLatency.record(10 * time.Millisecond)
+	}
+
+	// 一半的请求 owner 迟迟不响应，被对冲请求取代
+	for i := 0; i < 32; i++ {
+		for _, key := range []string{fmt.Sprintf("fast%d", i), fmt.Sprintf("slow%d", i)} {
+			if _, err := g.Get(key); err != nil {
+				t.Fatalf("Get(%s) error = %v", key, err)
+			}
+		}
+	}
+	if d, _ := g.hedgeDelay(); d < 10*time.Millisecond {
+		t.Errorf("hedgeDelay = %v after the owner was slow half the time; want at least 10ms", d)
+	}
+}
@@ -1,15 +1,24 @@
-package main
+package dailzCache
 
 import (
+	"bufio"
 	"bytes"
-	"dailzCache/consistentHash"
-	pb "dailzCache/dailzCachepb"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/dailz1/dailzCache/consistentHash"
+	pb "github.com/dailz1/dailzCache/dailzCachepb"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -17,6 +26,10 @@ import (
 const (
 	defaultBasePath = "/_daiCache/"
 	defaultReplicas = 50
+
+	defaultMaxRequestBytes = 64 << 20
+	// maxErrorBytes bounds the error message read from a peer's response.
+	maxErrorBytes = 1 << 10
 )
 
 // HTTPPool implements PeerPicker for a pool of HTTP peers.
@@ -24,12 +37,12 @@ type HTTPPool struct {
 	// Context optionally specifies a context for the server to use when it
 	// receives a request.
 	// If nil, the server uses the request's context
-	//Context func(r *Http.Request) context.Context
+	Context func(r *http.Request) context.Context
 
-	// Transport optionally specifies an Http.RoundTripper for the client
+	// Transport optionally specifies an http.RoundTripper for the client
 	// to use when it makes a request.
-	// If nil, the client uses Http.DefaultTransport.
-	//Transport func(context.Context) Http.RoundTripper
+	// If nil, the client uses http.DefaultTransport.
+	Transport func(context.Context) http.RoundTripper
 
 	// this peer's base URL, e.g. "https://example.net:8000"
 	self string
@@ -37,6 +50,9 @@ type HTTPPool struct {
 	// opts specifies the options.
 	opts HTTPPoolOptions
 
+	// universe holds the groups the pool serves.
+	universe *Universe
+
 	mu          sync.Mutex // guards peers and httpGetters
 	peers       *consistentHash.Map
 	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
@@ -55,6 +71,19 @@ type HTTPPoolOptions struct {
 	// HashFn specifies the hash function of the consistent hash.
 	// If blank, it defaults to crc32.ChecksumIEEE.
 	HashFn consistentHash.Hash
+
+	// ReplicationFactor specifies how many distinct peers own each key:
+	// the owner found by the consistent hash and the peers that follow
+	// it on the ring. Groups read from the replicas in order when the
+	// owner fails, see also GroupOptions.ReplicaFills.
+	// If blank, it defaults to 1.
+	ReplicationFactor int
+
+	// MaxRequestBytes limits the body of the requests the pool serves,
+	// and of Set requests to a group with a MaxValueSize the value size
+	// plus room for the rest of the request.
+	// If blank, it defaults to 64MB.
+	MaxRequestBytes int64
 }
 
 func (p *HTTPPool) Log(format string, v ...interface{}) {
@@ -71,53 +100,38 @@ func NewHTTPPool(self string) *HTTPPool {
 	return p
 }
 
-var httpPoolMade bool
-
 // NewHTTPPoolOpts initializes an HTTP pool of peers with the given options.
 // Unlike NewHTTPPool, this function does not register the created pool as an HTTP handler.
 // The returned *HTTPPool implements Http.Handler and must be registered using Http.Handle.
 func NewHTTPPoolOpts(self string, opts *HTTPPoolOptions) *HTTPPool {
-	if httpPoolMade {
-		panic("daiCache: NewHTTPPool must be called only once")
-	}
-
-	httpPoolMade = true
-
-	p := &HTTPPool{
-		self:        self,
-		httpGetters: make(map[string]*httpGetter),
-	}
-
-	if opts != nil {
-		p.opts = *opts
-	}
-	if p.opts.BasePath == "" {
-		p.opts.BasePath = defaultBasePath
-	}
-	if p.opts.Replicas == 0 {
-		p.opts.Replicas = defaultReplicas
-	}
-	p.peers = consistentHash.New(p.opts.Replicas, p.opts.HashFn)
-
-	RegisterPeerPicker(func() PeerPicker { return p })
-	return p
+	return defaultUniverse.NewHTTPPoolOpts(self, opts)
 }
 
-// Set updates the pool's list of peers.
+// Set updates the pool's list of peers, replacing the previous one.
 // Each peer value should be a valid base URL,
 // for example "http://example.net:8000".
+// Keys move only from peers that left and to peers that joined, and
+// the peers that stayed keep their connections.
 func (p *HTTPPool) Set(peers ...string) {
+	// 新的哈希环建好之后再一次性替换，PickPeer 不会看到只加入了一部分节点的环
+	ring := consistentHash.New(p.opts.Replicas, p.opts.HashFn)
+	ring.Add(peers...)
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	//p.peers = consistentHash.New(p.opts.Replicas, p.opts.HashFn)
-	p.peers.Add(peers...)
-	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	getters := make(map[string]*httpGetter, len(peers))
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{
-			//transport: p.Transport,
-			baseURL: peer + p.opts.BasePath,
+		if getter, ok := p.httpGetters[peer]; ok {
+			getters[peer] = getter
+			continue
+		}
+		getters[peer] = &httpGetter{
+			transport: p.Transport,
+			baseURL:   peer + p.opts.BasePath,
 		}
 	}
+	p.peers = ring
+	p.httpGetters = getters
 }
 
 func (p *HTTPPool) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
@@ -129,82 +143,632 @@ func (p *HTTPPool) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 
 	p.Log("%s %s", request.Method, request.URL.Path)
 
-	// 约定访问路径的格式为 /basePath/groupName/key
+	// 约定访问路径的格式为 /basePath/groupName/key，
+	// GET /basePath/groupName 返回该 group 的统计数据
 	parts := strings.SplitN(request.URL.Path[len(p.opts.BasePath):], "/", 2)
-	if len(parts) != 2 {
+	if len(parts) != 2 && request.Method != http.MethodGet {
 		http.Error(writer, "bad request", http.StatusBadRequest)
+		return
 	}
 
 	groupName := parts[0]
-	key := parts[1]
 
 	//p.Log("%v %v", groupName, key)
-	group := GetGroup(groupName)
+	group := p.universe.GetGroup(groupName)
 	if group == nil {
 		http.Error(writer, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
 
-	// 获取缓存数据
-	view, err := group.Get(key)
-	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	if len(parts) == 1 {
+		body, err := json.Marshal(groupStats(group))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(body)
 		return
 	}
+	key := parts[1]
+
+	ctx := request.Context()
+	if p.Context != nil {
+		ctx = p.Context(request)
+	}
+	// 其他节点发来的请求只在本节点加载，避免再次转发
+	ctx = withPeerRequest(ctx)
 
-	// 使用 proto.Marshal() 编码 HTTP 响应
-	body, err := proto.Marshal(&pb.GetResponse{Value: view.ByteSlice()})
+	switch request.Method {
+	case http.MethodDelete:
+		// DELETE 请求只删除本节点缓存中的 key，由发起删除的节点负责通知其他节点
+		group.invalidate(key)
+		return
+	case http.MethodPut:
+		// PUT 请求发往 key 的 owner，由 owner 写入新值并通知其他节点
+		limit := p.opts.MaxRequestBytes
+		if max := group.opts.MaxValueSize; max > 0 {
+			// 请求中除了值之外只有 group、key 和少量元数据
+			if n := max + int64(len(groupName)+len(key)) + 64; n < limit {
+				limit = n
+			}
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, limit))
+		if err != nil {
+			requestBodyError(writer, err)
+			return
+		}
+		req := &pb.SetRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.GetFill() {
+			// owner 推送的副本数据，只写入本节点缓存
+			err = group.localFill(key, req.GetValue(), req.GetExpire())
+		} else {
+			err = group.localSet(ctx, key, req.GetValue())
+		}
+		var removeErr *PeerRemoveError
+		if errors.As(err, &removeErr) {
+			// 新值已经写入，只是没能通知到所有节点，用 202 告诉请求方
+			p.Log("%v", err)
+			http.Error(writer, removeErr.Err.Error(), http.StatusAccepted)
+			return
+		}
+		if err == ErrGroupClosed {
+			// group 在查找之后被关闭或替换，请求方重试即可
+			http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err == ErrValueTooLarge {
+			http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	case http.MethodPost:
+		// POST 请求批量查询 GetMultiRequest 中的所有 key
+		body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, p.opts.MaxRequestBytes))
+		if err != nil {
+			requestBodyError(writer, err)
+			return
+		}
+		req := &pb.GetMultiRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err = proto.Marshal(getMultiResponse(group.getMulti(ctx, req.GetKeys())))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/octet-stream")
+		writer.Write(body)
+		return
+	}
+
+	// 获取缓存数据，请求方断开或超时后 ctx 随之取消
+	if request.URL.Query().Get("refresh") != "" {
+		ctx = withRefresh(ctx)
+	}
+	view, err := group.GetContext(ctx, key)
+	if IsNotFound(err) {
+		// key 不存在时返回带 not_found 标记的 404，以区别于没有该 group 的 404
+		body, _ := proto.Marshal(&pb.GetResponse{NotFound: true})
+		writer.Header().Set("Content-Type", "application/octet-stream")
+		writer.WriteHeader(http.StatusNotFound)
+		writer.Write(body)
+		return
+	}
+	if err == ErrGroupClosed {
+		// group 在查找之后被关闭或替换，请求方重试即可
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err == ErrValueTooLarge {
+		http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// 将缓存值作为 httpResponse 的 body 返回
+	writeGetResponse(writer, view)
+}
+
+// The numbers of the GetResponse and GetMultiResponse fields, which
+// writeGetResponse, readGetResponse and readGetMultiResponse encode and
+// decode by hand.
+var (
+	getResponseFields = (&pb.GetResponse{}).ProtoReflect().Descriptor().Fields()
+
+	valueField    = getResponseFields.ByName("value").Number()
+	expireField   = getResponseFields.ByName("expire").Number()
+	notFoundField = getResponseFields.ByName("not_found").Number()
+	chunksField   = getResponseFields.ByName("chunks").Number()
+
+	getMultiResponseFields = (&pb.GetMultiResponse{}).ProtoReflect().Descriptor().Fields()
+
+	valuesField = getMultiResponseFields.ByName("values").Number()
+	failedField = getMultiResponseFields.ByName("failed").Number()
+)
+
+// writeGetResponse writes getResponse(view) in the protobuf encoding,
+// writing the value straight from the view rather than marshalling a copy.
+// A value larger than chunkSize is sent as chunks, flushed one by one
+// with chunked transfer encoding.
+func writeGetResponse(w http.ResponseWriter, view ByteView) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	var head []byte
+	if e := view.Expire(); !e.IsZero() {
+		// expire 放在最前面，请求方不用等整个值传完就能知道
+		head = protowire.AppendTag(head, expireField, protowire.VarintType)
+		head = protowire.AppendVarint(head, uint64(e.UnixNano()))
+	}
+
+	if view.Len() <= chunkSize {
+		if view.Len() > 0 {
+			head = protowire.AppendTag(head, valueField, protowire.BytesType)
+			head = protowire.AppendVarint(head, uint64(view.Len()))
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(head)+view.Len()))
+		w.Write(head)
+		view.WriteTo(w)
+		return
 	}
-	writer.Header().Set("Content-Type", "application/octet-stream")
-	writer.Write(body) // 将缓存值作为 httpResponse 的 body 返回
+
+	flusher, _ := w.(http.Flusher)
+	view.forEachChunk(func(c []byte) error {
+		head = protowire.AppendTag(head, chunksField, protowire.BytesType)
+		head = protowire.AppendVarint(head, uint64(len(c)))
+		if _, err := w.Write(head); err != nil {
+			return err
+		}
+		head = head[:0]
+		if _, err := w.Write(c); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
 }
 
-type httpGetter struct {
-	baseURL string
+// requestBodyError replies to a request whose body could not be read.
+func requestBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
 }
 
-// sync.Pool 使用对象重用机制，sync.Pool 用于存储那些被分配了但是没有被使用的，
-// 未来可能会使用的值，这样不用再次经过内存分配，可以直接复用已有对象
-// sync.Pool 大小可伸缩，高负载时会动态扩容，存放在池中的对象如果不活跃，会被自动清理
-var bufferPool = sync.Pool{
-	New: func() interface{} { return new(bytes.Buffer) },
+// GroupStats is the JSON body served for GET basePath/groupName.
+type GroupStats struct {
+	Group     map[string]int64 // the counters of Group.Stats by field name
+	MainCache CacheStats
+	HotCache  CacheStats
+	// LingeringLoads is the number of load results held for
+	// GroupOptions.LoadLinger.
+	LingeringLoads int
 }
 
-// 查询 key 对应的 value 时，从 in.Group 所在的 peer 中获取
-func (h *httpGetter) Get(in *pb.GetRequest, out *pb.GetResponse) error {
+func groupStats(g *Group) GroupStats {
+	counters := make(map[string]int64)
+	v := reflect.ValueOf(&g.Stats).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if n, ok := v.Field(i).Addr().Interface().(*AtomicInt); ok {
+			counters[v.Type().Field(i).Name] = n.Get()
+		}
+	}
+	return GroupStats{
+		Group:     counters,
+		MainCache: g.CacheStats(MainCache),
+		HotCache:  g.CacheStats(HotCache),
+
+		LingeringLoads: g.loadGroup.Lingering(),
+	}
+}
+
+// getResponse encodes the view for a peer, a large value as its chunks.
+func getResponse(view ByteView) *pb.GetResponse {
+	res := &pb.GetResponse{Value: view.rawBytes()}
+	if view.chunks != nil {
+		res = &pb.GetResponse{Chunks: view.chunks}
+	}
+	if e := view.Expire(); !e.IsZero() {
+		res.Expire = e.UnixNano()
+	}
+	return res
+}
+
+type httpGetter struct {
+	transport func(context.Context) http.RoundTripper
+	baseURL   string
+}
+
+// do sends a request for the key of the group to the peer.
+func (h *httpGetter) do(ctx context.Context, method, group, key string, query url.Values, body io.Reader) (*http.Response, error) {
 	u := fmt.Sprintf("%v%v/%v",
-		h.baseURL, url.QueryEscape(in.GetGroup()), url.QueryEscape(in.GetKey()))
+		h.baseURL, url.QueryEscape(group), url.QueryEscape(key))
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
 	//log.Println(u)
-	res, err := http.Get(u)
+	// 请求绑定 ctx，调用方取消或超时后请求随之中断
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	tr := http.DefaultTransport
+	if h.transport != nil {
+		tr = h.transport(ctx)
+	}
+	return tr.RoundTrip(req)
+}
+
+// 查询 key 对应的 value 时，从 in.Group 所在的 peer 中获取
+func (h *httpGetter) Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	var query url.Values
+	if in.GetRefresh() {
+		query = url.Values{"refresh": {"1"}}
+	}
+	res, err := h.do(ctx, http.MethodGet, in.GetGroup(), in.GetKey(), query, nil)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
+	if res.StatusCode == http.StatusRequestEntityTooLarge {
+		return ErrValueTooLarge
+	}
+	if res.StatusCode != http.StatusOK && !isNotFoundResponse(res) {
 		return fmt.Errorf("server returned: %v", res.Status)
 	}
 
-	// Get() 用于从对象池中获取对象，返回值是 interface{} 因此需要类型转换
-	b := bufferPool.Get().(*bytes.Buffer)
-	b.Reset()
-	defer bufferPool.Put(b) // Put() 是在对象使用完毕后，返回对象池
+	// 值按块读取，不需要一次性缓冲整个响应，也只拷贝一次
+	err = readGetResponse(res.Body, res.ContentLength, maxValueSize(ctx), out)
+	if err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	if out.GetNotFound() {
+		return &NotFoundError{Key: in.GetKey()}
+	}
+	return nil
+}
 
-	_, err = io.Copy(b, res.Body)
-	//log.Println("[httpGetter]--Get:", b)
+// readGetResponse decodes a GetResponse as it is read from r, which
+// holds size bytes, or an unknown number if size is negative.
+// Values are read in pieces of at most chunkSize bytes, allocated as
+// they arrive, so that a large value is never buffered whole and a
+// length sent by the peer cannot make it allocate more than it sends.
+// A value larger than max, if positive, fails with ErrValueTooLarge
+// before it is read.
+func readGetResponse(r io.Reader, size, max int64, out *pb.GetResponse) error {
+	b := newBodyReader(r, size)
+	defer b.release()
+	return decodeGetResponse(b, max, out)
+}
+
+// decodeGetResponse decodes a GetResponse from the rest of b.
+func decodeGetResponse(b *bodyReader, max int64, out *pb.GetResponse) error {
+	out.Reset()
+	var total int64
+	for {
+		if b.sized && b.left <= 0 {
+			return nil
+		}
+		tag, err := binary.ReadUvarint(b)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		num, typ := protowire.DecodeTag(tag)
+		switch typ {
+		case protowire.VarintType:
+			v, err := binary.ReadUvarint(b)
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			switch num {
+			case expireField:
+				out.Expire = int64(v)
+			case notFoundField:
+				out.NotFound = v != 0
+			}
+		case protowire.BytesType:
+			n, err := b.length()
+			if err != nil {
+				return err
+			}
+			if num != valueField && num != chunksField {
+				if err := b.discard(n); err != nil {
+					return err
+				}
+				continue
+			}
+			total += n
+			if max > 0 && total > max {
+				return ErrValueTooLarge
+			}
+			if num == valueField && n <= chunkSize {
+				// 一块就能装下的值直接读入 Value
+				out.Value = make([]byte, n)
+				if err := b.readFull(out.Value); err != nil {
+					return err
+				}
+				continue
+			}
+			pieces, err := b.read(n)
+			if err != nil {
+				return err
+			}
+			out.Chunks = append(out.Chunks, pieces...)
+		default:
+			if err := b.skip(typ); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readGetMultiResponse decodes a GetMultiResponse for n keys as it is
+// read from r, which holds size bytes, or an unknown number if size is
+// negative. Each value is decoded like readGetResponse does.
+func readGetMultiResponse(r io.Reader, size, max int64, n int, out *pb.GetMultiResponse) error {
+	out.Reset()
+	b := newBodyReader(r, size)
+	defer b.release()
+	for {
+		tag, err := binary.ReadUvarint(b)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		num, typ := protowire.DecodeTag(tag)
+		switch {
+		case num == valuesField && typ == protowire.BytesType:
+			m, err := b.length()
+			if err != nil {
+				return err
+			}
+			if len(out.Values) == n {
+				return fmt.Errorf("more than %d values", n)
+			}
+			// 每个值都是一个嵌套的 GetResponse，直接从同一个 reader 中解码
+			value, sub := &pb.GetResponse{}, &bodyReader{br: b.br, sized: true, left: m}
+			if err := decodeGetResponse(sub, max, value); err != nil {
+				return err
+			}
+			if sub.left != 0 {
+				return fmt.Errorf("value overruns its %d bytes", m)
+			}
+			b.left -= m
+			out.Values = append(out.Values, value)
+		case num == failedField && typ == protowire.VarintType:
+			v, err := binary.ReadUvarint(b)
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			out.Failed = append(out.Failed, int32(v))
+		case num == failedField && typ == protowire.BytesType:
+			// failed 默认以 packed 编码发送
+			m, err := b.length()
+			if err != nil {
+				return err
+			}
+			if m > int64(n)*binary.MaxVarintLen64 {
+				return fmt.Errorf("failed indexes of %d bytes for %d keys", m, n)
+			}
+			pieces, err := b.read(m)
+			if err != nil {
+				return err
+			}
+			for packed := pieces[0]; len(packed) > 0; {
+				v, k := protowire.ConsumeVarint(packed)
+				if k < 0 {
+					return protowire.ParseError(k)
+				}
+				out.Failed = append(out.Failed, int32(v))
+				packed = packed[k:]
+			}
+		case typ == protowire.BytesType:
+			m, err := b.length()
+			if err != nil {
+				return err
+			}
+			if err := b.discard(m); err != nil {
+				return err
+			}
+		default:
+			if err := b.skip(typ); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// bodyReader reads a protobuf body, keeping track of how much of it is
+// left when its size is known.
+type bodyReader struct {
+	br    *bufio.Reader
+	sized bool
+	left  int64
+}
+
+// bodyReaders holds the bodyReaders, and the buffers they read through,
+// of responses already decoded.
+var bodyReaders = sync.Pool{
+	New: func() interface{} { return &bodyReader{br: bufio.NewReader(nil)} },
+}
+
+// newBodyReader returns a bodyReader of r, which holds size bytes, or an
+// unknown number if size is negative. It must be released once read.
+func newBodyReader(r io.Reader, size int64) *bodyReader {
+	b := bodyReaders.Get().(*bodyReader)
+	b.br.Reset(r)
+	b.sized, b.left = size >= 0, size
+	return b
+}
+
+func (b *bodyReader) release() {
+	b.br.Reset(nil)
+	bodyReaders.Put(b)
+}
+
+func (b *bodyReader) ReadByte() (byte, error) {
+	c, err := b.br.ReadByte()
+	if err == nil {
+		b.left--
+	}
+	return c, err
+}
+
+// length reads the length of a bytes field, which must fit in what is
+// left of the body.
+func (b *bodyReader) length() (int64, error) {
+	n, err := binary.ReadUvarint(b)
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+		return 0, unexpectedEOF(err)
+	}
+	if n > math.MaxInt64 || (b.sized && int64(n) > b.left) {
+		return 0, fmt.Errorf("field length %d exceeds the response body", n)
+	}
+	return int64(n), nil
+}
+
+// read reads n bytes in pieces of at most chunkSize bytes.
+func (b *bodyReader) read(n int64) ([][]byte, error) {
+	var pieces [][]byte
+	for n > 0 || pieces == nil {
+		k := n
+		if k > chunkSize {
+			k = chunkSize
+		}
+		p := make([]byte, k)
+		if err := b.readFull(p); err != nil {
+			return nil, err
+		}
+		n -= k
+		pieces = append(pieces, p)
+	}
+	return pieces, nil
+}
+
+func (b *bodyReader) readFull(p []byte) error {
+	if _, err := io.ReadFull(b.br, p); err != nil {
+		return unexpectedEOF(err)
+	}
+	b.left -= int64(len(p))
+	return nil
+}
+
+// skip skips a field of type typ other than a bytes field.
+func (b *bodyReader) skip(typ protowire.Type) error {
+	switch typ {
+	case protowire.VarintType:
+		_, err := binary.ReadUvarint(b)
+		return unexpectedEOF(err)
+	case protowire.Fixed32Type:
+		return b.discard(4)
+	case protowire.Fixed64Type:
+		return b.discard(8)
+	}
+	return fmt.Errorf("unexpected wire type %d", typ)
+}
+
+func (b *bodyReader) discard(n int64) error {
+	m, err := io.CopyN(io.Discard, b.br, n)
+	b.left -= m
+	return unexpectedEOF(err)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
 	}
-	// 使用 proto.Unmarshal() 解码 HTTP 响应
-	err = proto.Unmarshal(b.Bytes(), out)
+	return err
+}
+
+// isNotFoundResponse reports whether res is the 404 sent for a key that
+// does not exist, rather than for a group that does not.
+func isNotFoundResponse(res *http.Response) bool {
+	return res.StatusCode == http.StatusNotFound &&
+		res.Header.Get("Content-Type") == "application/octet-stream"
+}
+
+// GetMulti 一次请求查询 peer 上的多个 key
+func (h *httpGetter) GetMulti(ctx context.Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	res, err := h.do(ctx, http.MethodPost, in.GetGroup(), "", nil, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	// 与 Get 一样按块读取每个值，并受 MaxValueSize 限制
+	err = readGetMultiResponse(res.Body, res.ContentLength, maxValueSize(ctx), len(in.GetKeys()), out)
 	if err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
 	return nil
 }
 
+// Remove 通知 peer 删除其本地缓存中的 key
+func (h *httpGetter) Remove(ctx context.Context, in *pb.RemoveRequest) error {
+	res, err := h.do(ctx, http.MethodDelete, in.GetGroup(), in.GetKey(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+// Set 将新值发送给 key 的 owner
+func (h *httpGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	res, err := h.do(ctx, http.MethodPut, in.GetGroup(), in.GetKey(), nil, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusAccepted {
+		// 值已经写入 owner，但 owner 没能让其他节点删除旧的副本
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBytes))
+		return &PeerRemoveError{Key: in.GetKey(), Err: errors.New(strings.TrimSpace(string(msg)))}
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
 func (p *HTTPPool) PickPeer(key string) (ProtoGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -217,3 +781,37 @@ func (p *HTTPPool) PickPeer(key string) (ProtoGetter, bool) {
 	}
 	return nil, false
 }
+
+// Replication returns the ReplicationFactor of the pool.
+func (p *HTTPPool) Replication() int {
+	return p.opts.ReplicationFactor
+}
+
+// PickPeers returns up to n distinct peers for the key, the owner first,
+// with nil standing for the current peer.
+func (p *HTTPPool) PickPeers(key string, n int) []ProtoGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var peers []ProtoGetter
+	for _, peer := range p.peers.GetN(key, n) {
+		if peer == p.self {
+			peers = append(peers, nil)
+		} else {
+			peers = append(peers, p.httpGetters[peer])
+		}
+	}
+	return peers
+}
+
+// GetAll returns the getters of all peers except the current one.
+func (p *HTTPPool) GetAll() []ProtoGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]ProtoGetter, 0, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
@@ -1,12 +1,31 @@
-package main
+package dailzCache
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	pb "github.com/dailz1/dailzCache/dailzCachepb"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+var db = map[string]string{
+	"Tom":  "630",
+	"Jack": "589",
+	"Sam":  "567",
+}
+
 func TestHTTPPool(t *testing.T) {
 	NewGroup("scores", 2<<10, GetterFunc(
 		func(key string) ([]byte, error) {
@@ -61,3 +80,686 @@ func addToURL(addr []string) []string {
 	}
 	return url
 }
+
+func TestHTTPGetterContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+
+	h := &httpGetter{baseURL: ts.URL + defaultBasePath}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := h.Get(ctx, &pb.GetRequest{Group: "g", Key: "k"}, &pb.GetResponse{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get error = %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestHTTPPoolServesExpire(t *testing.T) {
+	expire := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	u := NewUniverse()
+	u.NewGroup("http-expire", 1<<10, ExpiringGetterFunc(
+		func(ctx context.Context, key string) ([]byte, time.Time, error) {
+			return []byte(key), expire, nil
+		}))
+	p := u.NewHTTPPoolOpts("", nil)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	h := &httpGetter{baseURL: ts.URL + defaultBasePath}
+	res := &pb.GetResponse{}
+	if err := h.Get(context.Background(), &pb.GetRequest{Group: "http-expire", Key: "key"}, res); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if got := time.Unix(0, res.Expire); !got.Equal(expire) {
+		t.Errorf("Expire = %v; want %v", got, expire)
+	}
+}
+
+func TestHTTPPoolServesStats(t *testing.T) {
+	u := NewUniverse()
+	g := u.NewGroup("http-stats", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	p := u.NewHTTPPoolOpts("", nil)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	g.Get("key")
+	g.Get("key")
+	res, err := http.Get(ts.URL + defaultBasePath + "http-stats")
+	if err != nil {
+		t.Fatalf("Get stats error = %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("stats status = %d; want %d", res.StatusCode, http.StatusOK)
+	}
+	var stats GroupStats
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatalf("decoding stats: %v", err)
+	}
+	if got := stats.Group["Gets"]; got != 2 {
+		t.Errorf("Gets = %d; want 2", got)
+	}
+	if got, want := stats.MainCache, g.CacheStats(MainCache); got != want {
+		t.Errorf("MainCache = %+v; want %+v", got, want)
+	}
+}
+
+func TestHTTPPoolRemove(t *testing.T) {
+	u := NewUniverse()
+	g := u.NewGroup("http-remove", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	p := u.NewHTTPPoolOpts("", nil)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	if _, err := g.Get("key"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	h := &httpGetter{baseURL: ts.URL + defaultBasePath}
+	if err := h.Remove(context.Background(), &pb.RemoveRequest{Group: "http-remove", Key: "key"}); err != nil {
+		t.Fatalf("Remove error = %v", err)
+	}
+	if _, ok := g.lookupCache("key"); ok {
+		t.Errorf("key still cached after a remote Remove")
+	}
+}
+
+func TestHTTPPoolSet(t *testing.T) {
+	origin := &store{data: map[string]string{}}
+	u := NewUniverse()
+	g := u.NewGroup("http-set", 1<<10, origin)
+	p := u.NewHTTPPoolOpts("", nil)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	h := &httpGetter{baseURL: ts.URL + defaultBasePath}
+	req := &pb.SetRequest{Group: "http-set", Key: "key", Value: []byte("value")}
+	if err := h.Set(context.Background(), req); err != nil {
+		t.Fatalf("Set error = %v", err)
+	}
+	if got := origin.data["key"]; got != "value" {
+		t.Errorf("origin has %q; want %q", got, "value")
+	}
+	if view, err := g.Get("key"); err != nil || view.String() != "value" {
+		t.Errorf("Get = %q, %v; want %q", view.String(), err, "value")
+	}
+}
+
+func TestHTTPPoolNotFound(t *testing.T) {
+	u := NewUniverse()
+	u.NewGroup("http-not-found", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, &NotFoundError{Key: key}
+	}))
+	p := u.NewHTTPPoolOpts("", nil)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	h := &httpGetter{baseURL: ts.URL + defaultBasePath}
+	err := h.Get(context.Background(), &pb.GetRequest{Group: "http-not-found", Key: "key"}, &pb.GetResponse{})
+	if !IsNotFound(err) {
+		t.Errorf("Get error = %v; want a NotFoundError", err)
+	}
+
+	err = h.Get(context.Background(), &pb.GetRequest{Group: "no-such-group", Key: "key"}, &pb.GetResponse{})
+	if err == nil || IsNotFound(err) {
+		t.Errorf("Get of a missing group error = %v; want a server error", err)
+	}
+}
+
+func TestHTTPPoolClosedGroup(t *testing.T) {
+	u := NewUniverse()
+	g := u.NewGroup("http-closed", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	p := u.NewHTTPPoolOpts("", nil)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	g.Close()
+	res, err := http.Get(ts.URL + defaultBasePath + "http-closed/key")
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d; want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestHTTPPoolGroupClosedAfterLookup(t *testing.T) {
+	u := NewUniverse()
+	g := u.NewGroup("http-closed-late", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	p := u.NewHTTPPoolOpts("", nil)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	// as if the request looked the group up just before it was replaced
+	g.Close()
+	u.mu.Lock()
+	u.groups["http-closed-late"] = g
+	u.mu.Unlock()
+
+	body, _ := proto.Marshal(&pb.SetRequest{Group: "http-closed-late", Key: "key", Value: []byte("value")})
+	for _, method := range []string{http.MethodGet, http.MethodPut} {
+		req, _ := http.NewRequest(method, ts.URL+defaultBasePath+"http-closed-late/key", bytes.NewReader(body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%s status = %d; want %d", method, res.StatusCode, http.StatusServiceUnavailable)
+		}
+	}
+}
+
+func TestHTTPPoolSetPeerRemoveError(t *testing.T) {
+	dead := httptest.NewServer(nil)
+	dead.Close()
+
+	origin := &store{data: map[string]string{}}
+	u := NewUniverse()
+	owner := u.NewGroup("http-set-remove-error", 1<<10, origin)
+	ts := httptest.NewUnstartedServer(nil)
+	self := "http://" + ts.Listener.Addr().String()
+	p := u.NewHTTPPoolOpts(self, nil)
+	p.Set(self, dead.URL)
+	ts.Config.Handler = p
+	ts.Start()
+	defer ts.Close()
+
+	g := NewUniverse().NewGroup("http-set-remove-error", 1<<10, origin)
+	g.RegisterPeers(replicaPeers{&httpGetter{baseURL: ts.URL + defaultBasePath}})
+	err := g.Set("key", []byte("new"))
+	var removeErr *PeerRemoveError
+	if !errors.As(err, &removeErr) || removeErr.Key != "key" {
+		t.Fatalf("Set error = %v; want a *PeerRemoveError for %q", err, "key")
+	}
+	if view, ok := owner.mainCache.get("key"); !ok || view.String() != "new" {
+		t.Errorf("owner caches %q, %v; want %q", view.String(), ok, "new")
+	}
+}
+
+func TestHTTPPoolGetMulti(t *testing.T) {
+	u := NewUniverse()
+	u.NewGroup("http-get-multi", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		switch key {
+		case "missing":
+			return nil, &NotFoundError{Key: key}
+		case "fail":
+			return nil, errors.New("origin down")
+		}
+		return []byte("value-" + key), nil
+	}))
+	p := u.NewHTTPPoolOpts("", nil)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	h := &httpGetter{baseURL: ts.URL + defaultBasePath}
+	req := &pb.GetMultiRequest{Group: "http-get-multi", Keys: []string{"a", "missing", "fail", "b"}}
+	res := &pb.GetMultiResponse{}
+	if err := h.GetMulti(context.Background(), req, res); err != nil {
+		t.Fatalf("GetMulti error = %v", err)
+	}
+	if len(res.Values) != 4 {
+		t.Fatalf("got %d values; want 4", len(res.Values))
+	}
+	if string(res.Values[0].Value) != "value-a" || string(res.Values[3].Value) != "value-b" {
+		t.Errorf("values = %q, %q; want value-a, value-b", res.Values[0].Value, res.Values[3].Value)
+	}
+	if !res.Values[1].NotFound {
+		t.Errorf("missing key not reported as not found")
+	}
+	if len(res.Failed) != 1 || res.Failed[0] != 2 {
+		t.Errorf("failed = %v; want [2]", res.Failed)
+	}
+}
+
+// BenchmarkPeerGet gets a 64KB value from a peer over HTTP into a
+// ByteView, see B/op for the copies made on the way.
+func BenchmarkPeerGet(b *testing.B) {
+	benchmarkPeerGet(b, func(h *httpGetter, req *pb.GetRequest) (ByteView, error) {
+		res := &pb.GetResponse{}
+		if err := h.Get(context.Background(), req, res); err != nil {
+			return ByteView{}, err
+		}
+		return viewOf(res.Value), nil
+	})
+}
+
+// copyBuffers stands for the buffer pool values used to be read into.
+var copyBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// BenchmarkPeerGetCopy is BenchmarkPeerGet the way it used to be done:
+// the body copied into a pooled buffer, unmarshalled, and the value
+// copied again by ByteSlice before it is handed out.
+func BenchmarkPeerGetCopy(b *testing.B) {
+	benchmarkPeerGet(b, func(h *httpGetter, req *pb.GetRequest) (ByteView, error) {
+		res, err := h.do(context.Background(), http.MethodGet, req.Group, req.Key, nil, nil)
+		if err != nil {
+			return ByteView{}, err
+		}
+		defer res.Body.Close()
+		buf := copyBuffers.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer copyBuffers.Put(buf)
+		if _, err := io.Copy(buf, res.Body); err != nil {
+			return ByteView{}, err
+		}
+		out := &pb.GetResponse{}
+		if err := proto.Unmarshal(buf.Bytes(), out); err != nil {
+			return ByteView{}, err
+		}
+		return ByteView{data: ByteView{data: out.Value}.ByteSlice()}, nil
+	})
+}
+
+func benchmarkPeerGet(b *testing.B, get func(*httpGetter, *pb.GetRequest) (ByteView, error)) {
+	value := bytes.Repeat([]byte("x"), 64<<10)
+	u := NewUniverse()
+	u.NewGroup("bench", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return value, nil
+	}))
+	p := u.NewHTTPPoolOpts("", nil)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	h := &httpGetter{baseURL: ts.URL + defaultBasePath}
+	req := &pb.GetRequest{Group: "bench", Key: "key"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if view, err := get(h, req); err != nil || view.Len() != len(value) {
+			b.Fatalf("got %d bytes, %v; want %d", view.Len(), err, len(value))
+		}
+	}
+}
+
+func TestGetResponseRoundTrip(t *testing.T) {
+	for _, want := range []*pb.GetResponse{
+		{},
+		{Value: []byte("value")},
+		{Value: []byte("value"), Expire: time.Now().UnixNano()},
+		{NotFound: true},
+		{Chunks: [][]byte{[]byte("chunk"), []byte("s")}, Expire: 1},
+	} {
+		b, err := proto.Marshal(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// an unknown field, as sent by a newer peer, is skipped
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendBytes(b, []byte("unknown"))
+
+		for _, size := range []int64{-1, int64(len(b))} {
+			got := &pb.GetResponse{Value: []byte("stale"), Expire: 1}
+			if err := readGetResponse(bytes.NewReader(b), size, 0, got); err != nil || !proto.Equal(got, want) {
+				t.Errorf("readGetResponse(size %d) = %v, %v; want %v", size, got, err, want)
+			}
+		}
+	}
+
+	if err := readGetResponse(bytes.NewReader([]byte{0x0a, 0x05, 'v'}), -1, 0, &pb.GetResponse{}); err != io.ErrUnexpectedEOF {
+		t.Errorf("readGetResponse of a truncated value error = %v; want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	large := bytes.Repeat([]byte("0123456789"), 2*chunkSize/10+1)
+	expire := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	largeView := makeView(large)
+	largeView.e = expire
+	for _, view := range []ByteView{
+		{},
+		{data: []byte("value")},
+		{data: []byte("value"), e: expire},
+		largeView,
+	} {
+		w := httptest.NewRecorder()
+		writeGetResponse(w, view)
+		body := w.Body.Bytes()
+
+		res := &pb.GetResponse{}
+		if err := proto.Unmarshal(body, res); err != nil {
+			t.Fatalf("proto.Unmarshal of writeGetResponse(%d bytes) error = %v", view.Len(), err)
+		}
+		if got := bytes.Join(append([][]byte{res.Value}, res.Chunks...), nil); !view.EqualBytes(got) {
+			t.Errorf("proto.Unmarshal of writeGetResponse has %d bytes; want %d", len(got), view.Len())
+		}
+		if res.Expire != getResponse(view).Expire {
+			t.Errorf("proto.Unmarshal of writeGetResponse has expire %d; want %d", res.Expire, getResponse(view).Expire)
+		}
+
+		got := &pb.GetResponse{}
+		if err := readGetResponse(bytes.NewReader(body), int64(len(body)), 0, got); err != nil || !proto.Equal(got, res) {
+			t.Errorf("readGetResponse of writeGetResponse(%d bytes) = %v; want what proto.Unmarshal decodes", view.Len(), err)
+		}
+	}
+}
+
+func TestReadGetResponseBounds(t *testing.T) {
+	huge := protowire.AppendTag(nil, 5, protowire.BytesType)
+	huge = protowire.AppendVarint(huge, 1<<62)
+	huge = append(huge, "chunk"...)
+	overflow := protowire.AppendTag(nil, 9, protowire.BytesType)
+	overflow = protowire.AppendVarint(overflow, 1<<63)
+	value, _ := proto.Marshal(&pb.GetResponse{Value: []byte("0123456789")})
+
+	for _, test := range []struct {
+		name      string
+		body      []byte
+		size, max int64
+		tooLarge  bool
+	}{
+		{"length beyond the body", huge, int64(len(huge)), 0, false},
+		{"length beyond an unknown body", huge, -1, 0, false},
+		{"length beyond int", overflow, -1, 0, false},
+		{"length beyond max", huge, -1, 1 << 20, true},
+		{"value beyond max", value, int64(len(value)), 9, true},
+	} {
+		err := readGetResponse(bytes.NewReader(test.body), test.size, test.max, &pb.GetResponse{})
+		if err == nil {
+			t.Errorf("%s: readGetResponse succeeded", test.name)
+		}
+		if (err == ErrValueTooLarge) != test.tooLarge {
+			t.Errorf("%s: readGetResponse error = %v; too large %v", test.name, err, test.tooLarge)
+		}
+	}
+}
+
+func TestReadGetMultiResponse(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), chunkSize+1)
+	want := &pb.GetMultiResponse{
+		Values: []*pb.GetResponse{
+			{Value: []byte("value"), Expire: 1},
+			{NotFound: true},
+			{},
+			getResponse(makeView(large)),
+		},
+		Failed: []int32{2},
+	}
+	b, err := proto.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int64{-1, int64(len(b))} {
+		got := &pb.GetMultiResponse{}
+		if err := readGetMultiResponse(bytes.NewReader(b), size, 0, 4, got); err != nil || !proto.Equal(got, want) {
+			t.Errorf("readGetMultiResponse(size %d) = %v; want %v", size, err, want)
+		}
+	}
+
+	if err := readGetMultiResponse(bytes.NewReader(b), -1, chunkSize, 4, &pb.GetMultiResponse{}); err != ErrValueTooLarge {
+		t.Errorf("readGetMultiResponse with max error = %v; want %v", err, ErrValueTooLarge)
+	}
+	if err := readGetMultiResponse(bytes.NewReader(b), -1, 0, 3, &pb.GetMultiResponse{}); err == nil {
+		t.Errorf("readGetMultiResponse of more values than keys succeeded")
+	}
+}
+
+func TestHTTPPoolLimitsRequestBody(t *testing.T) {
+	u := NewUniverse()
+	u.NewGroupOpts("http-limit", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), &GroupOptions{MaxValueSize: 10})
+	p := u.NewHTTPPoolOpts("", &HTTPPoolOptions{MaxRequestBytes: 1 << 10})
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	for _, test := range []struct {
+		method string
+		body   proto.Message
+	}{
+		{http.MethodPut, &pb.SetRequest{Group: "http-limit", Key: "key", Value: bytes.Repeat([]byte("x"), 200)}},
+		{http.MethodPost, &pb.GetMultiRequest{Group: "http-limit", Keys: strings.Split(strings.Repeat("key,", 300), ",")}},
+	} {
+		body, _ := proto.Marshal(test.body)
+		req, _ := http.NewRequest(test.method, ts.URL+defaultBasePath+"http-limit/key", bytes.NewReader(body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s of %d bytes status = %d; want %d", test.method, len(body), res.StatusCode, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+func TestHTTPPoolGetLargeValue(t *testing.T) {
+	value := bytes.Repeat([]byte("0123456789"), 10<<10)
+	expire := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	u := NewUniverse()
+	u.NewGroup("http-large", 1<<20, ExpiringGetterFunc(
+		func(ctx context.Context, key string) ([]byte, time.Time, error) {
+			return value, expire, nil
+		}))
+	p := u.NewHTTPPoolOpts("", nil)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	h := &httpGetter{baseURL: ts.URL + defaultBasePath}
+	res := &pb.GetResponse{}
+	if err := h.Get(context.Background(), &pb.GetRequest{Group: "http-large", Key: "key"}, res); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if !bytes.Equal(res.Value, value) {
+		t.Errorf("Get returned %d bytes; want the %d bytes served", len(res.Value), len(value))
+	}
+	if got := time.Unix(0, res.Expire); !got.Equal(expire) {
+		t.Errorf("Expire = %v; want %v", got, expire)
+	}
+}
+
+func benchmarkDecode(b *testing.B, decode func([]byte, *pb.GetResponse) error) {
+	body, _ := proto.Marshal(&pb.GetResponse{Value: bytes.Repeat([]byte("x"), 64<<10), Expire: 1})
+	out := &pb.GetResponse{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := decode(body, out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeProto(b *testing.B) {
+	benchmarkDecode(b, func(body []byte, out *pb.GetResponse) error {
+		return proto.Unmarshal(body, out)
+	})
+}
+
+func BenchmarkDecodeStream(b *testing.B) {
+	r := bytes.NewReader(nil)
+	benchmarkDecode(b, func(body []byte, out *pb.GetResponse) error {
+		r.Reset(body)
+		return readGetResponse(r, int64(len(body)), 0, out)
+	})
+}
+
+func TestHTTPPoolStreamsLargeValue(t *testing.T) {
+	value := bytes.Repeat([]byte("0123456789"), 4*chunkSize/10+1)
+	expire := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	var (
+		servers [2]*httptest.Server
+		addrs   [2]string
+		groups  [2]*Group
+		pools   [2]*HTTPPool
+	)
+	for i := range servers {
+		servers[i] = httptest.NewUnstartedServer(nil)
+		addrs[i] = "http://" + servers[i].Listener.Addr().String()
+	}
+	for i := range servers {
+		i := i
+		u := NewUniverse()
+		groups[i] = u.NewGroup("http-stream", 8<<20, ExpiringGetterFunc(
+			func(ctx context.Context, key string) ([]byte, time.Time, error) {
+				if i != 0 {
+					return nil, time.Time{}, errors.New("only node 0 loads")
+				}
+				return value, expire, nil
+			}))
+		pools[i] = u.NewHTTPPoolOpts(addrs[i], nil)
+		pools[i].Set(addrs[:]...)
+		servers[i].Config.Handler = pools[i]
+		servers[i].Start()
+		defer servers[i].Close()
+	}
+	key := "key"
+	for k := 0; ; k++ {
+		if peer, ok := pools[1].PickPeer(key); ok && peer != nil {
+			break
+		}
+		key = fmt.Sprint("key", k)
+	}
+
+	res, err := http.Get(addrs[0] + defaultBasePath + "http-stream/" + key)
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	res.Body.Close()
+	if res.ContentLength != -1 || len(res.TransferEncoding) == 0 || res.TransferEncoding[0] != "chunked" {
+		t.Errorf("large value sent with length %d, encoding %v; want chunked", res.ContentLength, res.TransferEncoding)
+	}
+
+	view, err := groups[1].Get(key)
+	if err != nil {
+		t.Fatalf("Get from peer error = %v", err)
+	}
+	if len(view.chunks) != 5 {
+		t.Errorf("value from peer has %d chunks; want 5", len(view.chunks))
+	}
+	if !view.EqualBytes(value) {
+		t.Errorf("value from peer has %d bytes; want the %d bytes of the owner", view.Len(), len(value))
+	}
+	if !view.Expire().Equal(expire) {
+		t.Errorf("Expire = %v; want %v", view.Expire(), expire)
+	}
+}
+
+func TestHTTPPoolRefreshReloadsOnOwner(t *testing.T) {
+	var version AtomicInt
+	var (
+		servers [2]*httptest.Server
+		addrs   [2]string
+		groups  [2]*Group
+		pools   [2]*HTTPPool
+	)
+	for i := range servers {
+		servers[i] = httptest.NewUnstartedServer(nil)
+		addrs[i] = "http://" + servers[i].Listener.Addr().String()
+	}
+	for i := range servers {
+		i := i
+		u := NewUniverse()
+		groups[i] = u.NewGroupOpts("http-refresh", 1<<10, ExpiringGetterFunc(
+			func(ctx context.Context, key string) ([]byte, time.Time, error) {
+				if i != 0 {
+					return nil, time.Time{}, errors.New("only node 0 loads")
+				}
+				version.Add(1)
+				return []byte(fmt.Sprint(version.Get())), time.Now().Add(20 * time.Millisecond), nil
+			}), &GroupOptions{StaleWhileRevalidate: time.Hour, HotAdmission: NewFrequencyAdmission(1, 0)})
+		pools[i] = u.NewHTTPPoolOpts(addrs[i], nil)
+		pools[i].Set(addrs[:]...)
+		servers[i].Config.Handler = pools[i]
+		servers[i].Start()
+		defer servers[i].Close()
+	}
+	key := "key"
+	for k := 0; ; k++ {
+		if peer, ok := pools[1].PickPeer(key); ok && peer != nil {
+			break
+		}
+		key = fmt.Sprint("key", k)
+	}
+
+	if view, err := groups[1].Get(key); err != nil || view.String() != "1" {
+		t.Fatalf("Get = %q, %v; want %q", view.String(), err, "1")
+	}
+	time.Sleep(30 * time.Millisecond)
+	// both nodes only have the expired value, the refresh of node 1 must
+	// make the owner reload it rather than hand back its stale copy
+	if view, err := groups[1].Get(key); err != nil || view.String() != "1" {
+		t.Fatalf("Get = %q, %v; want stale %q", view.String(), err, "1")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if view, _ := groups[1].lookupCache(key); view.String() == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			view, _ := groups[1].lookupCache(key)
+			t.Fatalf("cached %q after refresh; want %q", view.String(), "2")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := groups[1].Stats.RefreshErrors.Get(); got != 0 {
+		t.Errorf("RefreshErrors = %d; want 0", got)
+	}
+}
+
+func TestHTTPPoolSetReplacesPeers(t *testing.T) {
+	p := NewUniverse().NewHTTPPoolOpts("http://self", nil)
+	owners := func() map[string]ProtoGetter {
+		m := make(map[string]ProtoGetter)
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprint("key", i)
+			peer, _ := p.PickPeer(key)
+			m[key] = peer
+		}
+		return m
+	}
+
+	p.Set("http://self", "http://a", "http://b", "http://c")
+	getterB, getterC := p.httpGetters["http://b"], p.httpGetters["http://c"]
+	before := owners()
+
+	// the same list again changes nothing
+	p.Set("http://self", "http://a", "http://b", "http://c")
+	if got := len(p.GetAll()); got != 3 {
+		t.Errorf("GetAll has %d peers after setting the same 3 others twice; want 3", got)
+	}
+	for key, owner := range owners() {
+		if owner != before[key] {
+			t.Fatalf("%s moved after setting the same peers again", key)
+		}
+	}
+
+	// c leaves and d joins: keys move only from c and to d
+	p.Set("http://self", "http://a", "http://b", "http://d")
+	if p.httpGetters["http://b"] != getterB {
+		t.Errorf("Set replaced the getter of a peer that stayed")
+	}
+	getterD := p.httpGetters["http://d"]
+	moved := 0
+	for key, owner := range owners() {
+		if owner == getterC {
+			t.Fatalf("%s still maps to the peer that left", key)
+		}
+		if owner != before[key] {
+			moved++
+			if before[key] != getterC && owner != getterD {
+				t.Errorf("%s moved between peers that stayed", key)
+			}
+		}
+	}
+	if moved == 0 {
+		t.Errorf("no key moved after replacing a peer")
+	}
+}
@@ -2,36 +2,158 @@ package lru
 
 import "container/list"
 
+// historyCache holds the entries referenced fewer than k times,
+// together with the number of times they have been referenced.
 type historyCache struct {
-	cache    Cache
-	countMap map[Key]int
-	k        int
+	maxEntries int
+	list       *list.List
+	cache      map[Key]*list.Element
 }
 
+// kEntry is an entry of an LRUKCache.
+type kEntry struct {
+	key   Key
+	value Value
+	count int // number of references, only counted while in history
+}
+
+// LRUKCache is an LRU-K cache. An entry stays in the history queue until
+// it has been referenced k times, and only then joins the cache queue.
+// Entries referenced once by a scan therefore never push out the ones
+// in the cache queue. It is not safe for concurrent access.
 type LRUKCache struct {
+	k            int
 	historyCache *historyCache
 	maxEntries   int
 	list         *list.List
 	cache        map[Key]*list.Element
+
+	// onEvicted optionally specifies a callback function to be
+	// executed when an entry is purged from the cache.
+	onEvicted func(key Key, value Value)
 }
 
-func NewLRUK(LRUKMaxEntries int, LRUMaxEntries int, onEvicted func(Key, Value)) *LRUKCache {
-	historyCache := &historyCache{
-		cache:    *New(LRUMaxEntries, onEvicted),
-		countMap: nil,
-		k:        0,
+// NewLRUK creates a new LRUKCache.
+// LRUKMaxEntries and historyMaxEntries limit the entries of the cache
+// and history queues, zero means no limit and that eviction is done
+// by the caller.
+func NewLRUK(k int, LRUKMaxEntries int, historyMaxEntries int, onEvicted func(Key, Value)) *LRUKCache {
+	if k < 1 {
+		k = 1
 	}
 	return &LRUKCache{
-		historyCache: historyCache,
-		maxEntries:   LRUKMaxEntries,
-		list:         list.New(),
-		cache:        make(map[Key]*list.Element),
+		k: k,
+		historyCache: &historyCache{
+			maxEntries: historyMaxEntries,
+			list:       list.New(),
+			cache:      make(map[Key]*list.Element),
+		},
+		maxEntries: LRUKMaxEntries,
+		list:       list.New(),
+		cache:      make(map[Key]*list.Element),
+		onEvicted:  onEvicted,
 	}
 }
 
+// Add adds a value to the cache, counting it as a reference.
 func (kc *LRUKCache) Add(key Key, value Value) {
-	if kc.historyCache.cache.cache == nil {
-		kc.historyCache.cache.cache = make(map[Key]*list.Element)
+	if element, ok := kc.cache[key]; ok {
+		kc.list.MoveToBack(element)
+		element.Value.(*kEntry).value = value
+		return
+	}
+
+	if element, ok := kc.historyCache.cache[key]; ok {
+		element.Value.(*kEntry).value = value
+		kc.reference(element)
+		return
+	}
+
+	h := kc.historyCache
+	h.cache[key] = h.list.PushBack(&kEntry{key: key, value: value})
+	kc.reference(h.cache[key])
+	if h.maxEntries != 0 && h.list.Len() > h.maxEntries {
+		kc.removeElement(h.list, h.cache, h.list.Front())
+	}
+}
+
+// Get looks up a key's value from the cache, counting it as a reference.
+func (kc *LRUKCache) Get(key Key) (value Value, ok bool) {
+	if element, ok := kc.cache[key]; ok {
+		kc.list.MoveToBack(element)
+		return element.Value.(*kEntry).value, true
+	}
+	if element, ok := kc.historyCache.cache[key]; ok {
+		value = element.Value.(*kEntry).value
+		kc.reference(element)
+		return value, true
+	}
+	return
+}
+
+// Peek looks up a key's value without counting it as a reference.
+func (kc *LRUKCache) Peek(key Key) (value Value, ok bool) {
+	if element, ok := kc.cache[key]; ok {
+		return element.Value.(*kEntry).value, true
+	}
+	if element, ok := kc.historyCache.cache[key]; ok {
+		return element.Value.(*kEntry).value, true
+	}
+	return
+}
+
+// reference counts a reference to a history entry and moves it to the
+// cache queue once it has been referenced k times.
+func (kc *LRUKCache) reference(element *list.Element) {
+	h := kc.historyCache
+	kv := element.Value.(*kEntry)
+	kv.count++
+	if kv.count < kc.k {
+		h.list.MoveToBack(element)
+		return
+	}
+
+	h.list.Remove(element)
+	delete(h.cache, kv.key)
+	kc.cache[kv.key] = kc.list.PushBack(kv)
+	if kc.maxEntries != 0 && kc.list.Len() > kc.maxEntries {
+		kc.removeElement(kc.list, kc.cache, kc.list.Front())
+	}
+}
+
+// Remove removes the provided key from the cache.
+func (kc *LRUKCache) Remove(key Key) {
+	if element, ok := kc.cache[key]; ok {
+		kc.removeElement(kc.list, kc.cache, element)
+		return
+	}
+	if element, ok := kc.historyCache.cache[key]; ok {
+		kc.removeElement(kc.historyCache.list, kc.historyCache.cache, element)
+	}
+}
+
+// RemoveOldest removes the oldest item of the history queue,
+// or of the cache queue when the history is empty.
+func (kc *LRUKCache) RemoveOldest() {
+	if element := kc.historyCache.list.Front(); element != nil {
+		kc.removeElement(kc.historyCache.list, kc.historyCache.cache, element)
+		return
+	}
+	if element := kc.list.Front(); element != nil {
+		kc.removeElement(kc.list, kc.cache, element)
+	}
+}
 
+func (kc *LRUKCache) removeElement(l *list.List, cache map[Key]*list.Element, element *list.Element) {
+	l.Remove(element)
+	kv := element.Value.(*kEntry)
+	delete(cache, kv.key)
+	if kc.onEvicted != nil {
+		kc.onEvicted(kv.key, kv.value)
 	}
 }
+
+// Len the number of entries in both queues.
+func (kc *LRUKCache) Len() int {
+	return kc.list.Len() + kc.historyCache.list.Len()
+}
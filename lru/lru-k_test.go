@@ -0,0 +1,70 @@
+package lru
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLRUKPromote(t *testing.T) {
+	lruk := NewLRUK(2, 0, 0, nil)
+	lruk.Add("myKey1", 1)
+	if _, ok := lruk.cache["myKey1"]; ok {
+		t.Fatalf("myKey1 joined the cache queue after one reference")
+	}
+	if value, ok := lruk.Get("myKey1"); !ok || value != 1 {
+		t.Fatalf("Get myKey1 = %v, %v; want 1, true", value, ok)
+	}
+	if _, ok := lruk.cache["myKey1"]; !ok {
+		t.Fatalf("myKey1 is not in the cache queue after two references")
+	}
+
+	lruk.Add("myKey2", 2)
+	if _, ok := lruk.Peek("myKey2"); !ok {
+		t.Fatalf("Peek myKey2 failed")
+	}
+	if _, ok := lruk.cache["myKey2"]; ok {
+		t.Fatalf("Peek counted as a reference")
+	}
+}
+
+func TestLRUKScan(t *testing.T) {
+	var evicted []Key
+	lruk := NewLRUK(2, 0, 0, func(key Key, value Value) {
+		evicted = append(evicted, key)
+	})
+	lruk.Add("hot", 1)
+	lruk.Get("hot")
+	for i := 0; i < 3; i++ {
+		lruk.Add(fmt.Sprintf("scan%d", i), i)
+	}
+
+	for i := 0; i < 3; i++ {
+		lruk.RemoveOldest()
+	}
+	if _, ok := lruk.Get("hot"); !ok {
+		t.Fatalf("the scan evicted the hot key, evicted %v", evicted)
+	}
+	lruk.RemoveOldest()
+	if lruk.Len() != 0 || len(evicted) != 4 || evicted[3] != "hot" {
+		t.Fatalf("evicted %v; want the scan then hot", evicted)
+	}
+}
+
+func TestLRUKMaxEntries(t *testing.T) {
+	var evicted []Key
+	lruk := NewLRUK(2, 1, 2, func(key Key, value Value) {
+		evicted = append(evicted, key)
+	})
+	for i := 0; i < 3; i++ {
+		lruk.Add(fmt.Sprintf("myKey%d", i), i)
+	}
+	if len(evicted) != 1 || evicted[0] != "myKey0" {
+		t.Fatalf("evicted %v; want [myKey0]", evicted)
+	}
+
+	lruk.Get("myKey1")
+	lruk.Get("myKey2")
+	if len(evicted) != 2 || evicted[1] != "myKey1" || lruk.Len() != 1 {
+		t.Fatalf("evicted %v, len %d; want [myKey0 myKey1], 1", evicted, lruk.Len())
+	}
+}
@@ -80,6 +80,19 @@ func (c *Cache) Get(key Key) (value Value, ok bool) {
 	return
 }
 
+// Peek looks up a key's value from the cache without updating
+// the recent-ness of the key.
+func (c *Cache) Peek(key Key) (value Value, ok bool) {
+	if c.cache == nil {
+		return
+	}
+
+	if element, ok := c.cache[key]; ok {
+		return element.Value.(*entry).value, true
+	}
+	return
+}
+
 // Remove removes the provided key from the cache.
 func (c *Cache) Remove(key Key) {
 	if c.cache == nil {
@@ -61,6 +61,25 @@ func TestRemove(t *testing.T) {
 	}
 }
 
+func TestPeek(t *testing.T) {
+	lru := New(0, nil)
+	lru.Add("myKey1", 1)
+	lru.Add("myKey2", 2)
+
+	if value, ok := lru.Peek("myKey1"); !ok || value != 1 {
+		t.Fatalf("Peek myKey1 = %v, %v; want 1, true", value, ok)
+	}
+	if _, ok := lru.Peek("nonsense"); ok {
+		t.Fatalf("Peek returned a missing entry")
+	}
+
+	// Peek must not make myKey1 the most recently used entry.
+	lru.RemoveOldest()
+	if _, ok := lru.Get("myKey1"); ok {
+		t.Fatalf("Peek updated the recent-ness of myKey1")
+	}
+}
+
 func TestRemoveOldest(t *testing.T) {
 	cap := 5
 
@@ -0,0 +1,157 @@
+package lru2
+
+import "math"
+
+const (
+	// Default2QRecentRatio is the ratio of the 2Q cache dedicated
+	// to recently added entries that have only been accessed once.
+	Default2QRecentRatio = 0.25
+
+	// Default2QGhostEntries is the default number of recently evicted
+	// keys the 2Q cache remembers.
+	Default2QGhostEntries = 1024
+)
+
+// TwoQueue implements a non-thread safe 2Q cache without a fixed size,
+// the caller evicts entries with RemoveOldest. 2Q tracks entries accessed
+// once and entries accessed more often in separate LRU lists, so a scan
+// over many keys evicts the former and keeps the frequently used ones.
+type TwoQueue[K comparable, V any] struct {
+	recentRatio float64
+
+	recent      *LRU[K, V]        // entries accessed once
+	frequent    *LRU[K, V]        // entries accessed more than once
+	recentEvict *LRU[K, struct{}] // keys recently evicted from recent
+	onEvict     EvictCallback[K, V]
+}
+
+// NewTwoQueue constructs a 2Q cache. recentRatio is the share of entries
+// accessed only once that are kept before the frequent ones get evicted,
+// ghostEntries is the number of evicted keys remembered.
+func NewTwoQueue[K comparable, V any](recentRatio float64, ghostEntries int, onEvict EvictCallback[K, V]) *TwoQueue[K, V] {
+	if recentRatio < 0.0 || recentRatio > 1.0 {
+		recentRatio = Default2QRecentRatio
+	}
+	if ghostEntries <= 0 {
+		ghostEntries = Default2QGhostEntries
+	}
+
+	// the sizes are enforced by the caller, so recent and frequent never
+	// evict on their own
+	recent, _ := NewLRU[K, V](math.MaxInt, nil)
+	frequent, _ := NewLRU[K, V](math.MaxInt, nil)
+	recentEvict, _ := NewLRU[K, struct{}](ghostEntries, nil)
+	return &TwoQueue[K, V]{
+		recentRatio: recentRatio,
+		recent:      recent,
+		frequent:    frequent,
+		recentEvict: recentEvict,
+		onEvict:     onEvict,
+	}
+}
+
+// Get looks up a key's value from the cache, a hit on a recent entry
+// makes it frequent.
+func (c *TwoQueue[K, V]) Get(key K) (value V, ok bool) {
+	if value, ok = c.frequent.Get(key); ok {
+		return value, ok
+	}
+
+	if value, ok = c.recent.Peek(key); ok {
+		c.recent.Remove(key)
+		c.frequent.Add(key, value)
+		return value, ok
+	}
+	return
+}
+
+// Add adds a value to the cache.
+func (c *TwoQueue[K, V]) Add(key K, value V) {
+	if c.frequent.Contains(key) {
+		c.frequent.Add(key, value)
+		return
+	}
+
+	if c.recent.Contains(key) {
+		c.recent.Remove(key)
+		c.frequent.Add(key, value)
+		return
+	}
+
+	// a key evicted not long ago is coming back, so it is used frequently
+	if c.recentEvict.Contains(key) {
+		c.recentEvict.Remove(key)
+		c.frequent.Add(key, value)
+		return
+	}
+	c.recent.Add(key, value)
+}
+
+// Peek returns the key value without updating its recent-ness.
+func (c *TwoQueue[K, V]) Peek(key K) (value V, ok bool) {
+	if value, ok = c.frequent.Peek(key); ok {
+		return value, ok
+	}
+	return c.recent.Peek(key)
+}
+
+// Contains checks if a key is in the cache.
+func (c *TwoQueue[K, V]) Contains(key K) bool {
+	return c.frequent.Contains(key) || c.recent.Contains(key)
+}
+
+// Remove removes the provided key from the cache, returning true if the
+// key was contained.
+func (c *TwoQueue[K, V]) Remove(key K) bool {
+	if value, ok := c.frequent.Peek(key); ok {
+		c.frequent.Remove(key)
+		c.evicted(key, value)
+		return true
+	}
+	if value, ok := c.recent.Peek(key); ok {
+		c.recent.Remove(key)
+		c.evicted(key, value)
+		return true
+	}
+	c.recentEvict.Remove(key)
+	return false
+}
+
+// RemoveOldest evicts the oldest recent entry while recent entries take
+// more than their share of the cache, and the oldest frequent one otherwise.
+func (c *TwoQueue[K, V]) RemoveOldest() (key K, value V, ok bool) {
+	recentLen := c.recent.Len()
+	if recentLen > 0 && (float64(recentLen) >= c.recentRatio*float64(c.Len()) || c.frequent.Len() == 0) {
+		if key, value, ok = c.recent.RemoveOldest(); ok {
+			c.recentEvict.Add(key, struct{}{})
+		}
+	} else {
+		key, value, ok = c.frequent.RemoveOldest()
+	}
+	if ok {
+		c.evicted(key, value)
+	}
+	return
+}
+
+// Len returns the number of items in the cache.
+func (c *TwoQueue[K, V]) Len() int {
+	return c.recent.Len() + c.frequent.Len()
+}
+
+// Purge is used to completely clear the cache.
+func (c *TwoQueue[K, V]) Purge() {
+	for _, key := range c.frequent.Keys() {
+		c.Remove(key)
+	}
+	for _, key := range c.recent.Keys() {
+		c.Remove(key)
+	}
+	c.recentEvict.Purge()
+}
+
+func (c *TwoQueue[K, V]) evicted(key K, value V) {
+	if c.onEvict != nil {
+		c.onEvict(key, value)
+	}
+}
@@ -0,0 +1,73 @@
+package lru2
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test2QPromote(t *testing.T) {
+	c := NewTwoQueue[string, int](0.5, 0, nil)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	if c.frequent.Len() != 0 || c.recent.Len() != 2 {
+		t.Fatalf("recent, frequent = %d, %d; want 2, 0", c.recent.Len(), c.frequent.Len())
+	}
+
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get a = %v, %v; want 1, true", v, ok)
+	}
+	if !c.frequent.Contains("a") || c.recent.Contains("a") {
+		t.Fatalf("a was not promoted to frequent")
+	}
+	if v, ok := c.Peek("b"); !ok || v != 2 || c.frequent.Contains("b") {
+		t.Fatalf("Peek b = %v, %v, or it promoted b", v, ok)
+	}
+}
+
+func Test2QScanResistance(t *testing.T) {
+	var evicted []string
+	c := NewTwoQueue[string, int](0.25, 0, func(key string, value int) {
+		evicted = append(evicted, key)
+	})
+	for i := 0; i < 4; i++ {
+		key := fmt.Sprintf("hot%d", i)
+		c.Add(key, i)
+		c.Get(key)
+	}
+	for i := 0; i < 4; i++ {
+		c.Add(fmt.Sprintf("scan%d", i), i)
+	}
+
+	// the scanned keys take more than a quarter of the cache,
+	// so they must go before any of the hot ones
+	for i := 0; i < 3; i++ {
+		c.RemoveOldest()
+	}
+	for i, key := range evicted {
+		if want := fmt.Sprintf("scan%d", i); key != want {
+			t.Errorf("evicted[%d] = %s; want %s", i, key, want)
+		}
+	}
+
+	// a ghost hit brings the key straight back as frequent
+	c.Add("scan0", 0)
+	if !c.frequent.Contains("scan0") {
+		t.Errorf("scan0 re-added after eviction is not frequent")
+	}
+}
+
+func Test2QRemove(t *testing.T) {
+	var evicted []string
+	c := NewTwoQueue[string, int](0.25, 0, func(key string, value int) {
+		evicted = append(evicted, key)
+	})
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Get("b")
+	if !c.Remove("a") || !c.Remove("b") || c.Remove("c") {
+		t.Fatalf("Remove reported wrong presence")
+	}
+	if c.Len() != 0 || len(evicted) != 2 {
+		t.Fatalf("Len = %d, evicted = %v; want 0, [a b]", c.Len(), evicted)
+	}
+}
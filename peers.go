@@ -1,10 +1,20 @@
-package main
+package dailzCache
 
-import pb "dailzCache/dailzCachepb"
+import (
+	"context"
+	pb "github.com/dailz1/dailzCache/dailzCachepb"
+)
 
 // ProtoGetter is the interface that must be implemented by a peer.
+// Its methods must return once ctx is done.
 type ProtoGetter interface {
-	Get(in *pb.GetRequest, out *pb.GetResponse) error
+	Get(ctx context.Context, in *pb.GetRequest, out *pb.GetResponse) error
+	// Remove removes the key from the peer's own caches only.
+	Remove(ctx context.Context, in *pb.RemoveRequest) error
+	// Set stores the value on the peer, which must own the key.
+	Set(ctx context.Context, in *pb.SetRequest) error
+	// GetMulti gets the values of several keys the peer owns at once.
+	GetMulti(ctx context.Context, in *pb.GetMultiRequest, out *pb.GetMultiResponse) error
 }
 
 // PeerPicker is the interface that must be implemented to locate
@@ -15,6 +25,20 @@ type PeerPicker interface {
 	// and true to indicate that a remote peer was nominated.
 	// It returns nil, false if the key owner is the current peer.
 	PickPeer(key string) (peer ProtoGetter, ok bool)
+	// GetAll returns every peer except the current one.
+	GetAll() []ProtoGetter
+}
+
+// A ReplicaPicker is a PeerPicker that can also name the peers that
+// follow the owner of a key. A group tries them in order when the owner
+// fails to load a key.
+type ReplicaPicker interface {
+	// PickPeers returns up to n distinct peers for the key, the owner
+	// first. A nil ProtoGetter stands for the current peer.
+	PickPeers(key string, n int) []ProtoGetter
+	// Replication returns how many peers own each key, that is
+	// the owner and its replicas.
+	Replication() int
 }
 
 // NoPeers is an implementation of PeerPicker that never finds a peer.
@@ -25,43 +49,64 @@ func (NoPeers) PickPeer(key string) (peer ProtoGetter, ok bool) {
 	return
 }
 
-var (
-	portPicker func(groupName string) PeerPicker
-)
+func (NoPeers) GetAll() []ProtoGetter {
+	return nil
+}
 
 // RegisterPeerPicker registers the peer initialization function.
-// It is called once, when the first group is created.
+// It is called once for each group, when the group first needs its peers.
 // Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
 // called exactly once, but not both.
 func RegisterPeerPicker(fn func() PeerPicker) {
-	if portPicker != nil {
-		panic("RegisterPeerPicker called more than once")
-	}
-
-	portPicker = func(_ string) PeerPicker {
-		return fn()
-	}
+	defaultUniverse.RegisterPeerPicker(fn)
 }
 
 // RegisterPerGroupPeerPicker registers the peer initialization function,
 // which takes the groupName, to be used in choosing a PeerPicker.
-// It is called once, when the first group is created.
 // Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
 // called exactly once, but not both.
 func RegisterPerGroupPeerPicker(fn func(groupName string) PeerPicker) {
-	if portPicker != nil {
-		panic("RegisterPeerPicker called more than once")
-	}
-	portPicker = fn
+	defaultUniverse.RegisterPerGroupPeerPicker(fn)
 }
 
 func GetPeers(groupName string) PeerPicker {
-	if portPicker == nil {
-		return NoPeers{}
-	}
-	pk := portPicker(groupName)
-	if pk == nil {
-		pk = NoPeers{}
-	}
-	return pk
+	return defaultUniverse.GetPeers(groupName)
+}
+
+type peerRequestKey struct{}
+
+// withPeerRequest marks ctx as serving a request from another peer.
+// Such requests are loaded locally and never forwarded again.
+func withPeerRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, peerRequestKey{}, true)
+}
+
+func isPeerRequest(ctx context.Context) bool {
+	return ctx.Value(peerRequestKey{}) != nil
+}
+
+type refreshKey struct{}
+
+// withRefresh marks ctx as refreshing a stale value. Peers asked for the
+// value reload it instead of serving their own stale copy.
+func withRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey{}, true)
+}
+
+func isRefresh(ctx context.Context) bool {
+	return ctx.Value(refreshKey{}) != nil
+}
+
+type maxValueSizeKey struct{}
+
+// withMaxValueSize tells a ProtoGetter the largest value the group
+// accepts, so that it can stop reading a larger one early.
+func withMaxValueSize(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, maxValueSizeKey{}, n)
+}
+
+// maxValueSize returns the limit set by withMaxValueSize, or 0 for none.
+func maxValueSize(ctx context.Context) int64 {
+	n, _ := ctx.Value(maxValueSizeKey{}).(int64)
+	return n
 }
@@ -0,0 +1,150 @@
+package dailzCache
+
+import (
+	"github.com/dailz1/dailzCache/greedyDual"
+	"github.com/dailz1/dailzCache/lru"
+	"github.com/dailz1/dailzCache/lru2"
+)
+
+// An EvictionPolicy holds the entries of a cache and chooses which one
+// to evict when the group needs room. The cache serializes all calls,
+// so an EvictionPolicy need not be safe for concurrent use.
+type EvictionPolicy interface {
+	// Add adds or replaces the value of key.
+	Add(key string, value ByteView)
+	// Get looks up a key's value, counting it as an access.
+	Get(key string) (value ByteView, ok bool)
+	// Peek looks up a key's value without counting it as an access.
+	Peek(key string) (value ByteView, ok bool)
+	// Remove removes the key.
+	Remove(key string)
+	// RemoveOldest evicts the entry the policy values the least.
+	RemoveOldest()
+	// Len returns the number of entries.
+	Len() int
+}
+
+// A PolicyFunc creates an empty EvictionPolicy, which calls onEvicted
+// for every entry that leaves it, whether evicted or removed.
+type PolicyFunc func(onEvicted func(key string, value ByteView)) EvictionPolicy
+
+// LRUPolicy evicts the least recently used entry first.
+func LRUPolicy() PolicyFunc {
+	return func(onEvicted func(key string, value ByteView)) EvictionPolicy {
+		return &lruPolicy{lru.New(0, func(key lru.Key, value lru.Value) {
+			onEvicted(key.(string), value.(ByteView))
+		})}
+	}
+}
+
+type lruPolicy struct {
+	c *lru.Cache
+}
+
+func (p *lruPolicy) Add(key string, value ByteView) { p.c.Add(key, value) }
+func (p *lruPolicy) Remove(key string)              { p.c.Remove(key) }
+func (p *lruPolicy) RemoveOldest()                  { p.c.RemoveOldest() }
+func (p *lruPolicy) Len() int                       { return p.c.Len() }
+
+func (p *lruPolicy) Get(key string) (ByteView, bool) {
+	value, ok := p.c.Get(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	return value.(ByteView), true
+}
+
+func (p *lruPolicy) Peek(key string) (ByteView, bool) {
+	value, ok := p.c.Peek(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	return value.(ByteView), true
+}
+
+// TwoQueuePolicy keeps the entries accessed once apart from those
+// accessed again, and evicts the former first while they take more than
+// recentRatio of the cache. ghostEntries recently evicted keys are
+// remembered, so that they come back as frequent.
+// Zero values select lru2.Default2QRecentRatio and lru2.Default2QGhostEntries.
+func TwoQueuePolicy(recentRatio float64, ghostEntries int) PolicyFunc {
+	if recentRatio == 0 {
+		recentRatio = lru2.Default2QRecentRatio
+	}
+	return func(onEvicted func(key string, value ByteView)) EvictionPolicy {
+		return &twoQueuePolicy{lru2.NewTwoQueue[string, ByteView](recentRatio, ghostEntries, onEvicted)}
+	}
+}
+
+type twoQueuePolicy struct {
+	c *lru2.TwoQueue[string, ByteView]
+}
+
+func (p *twoQueuePolicy) Add(key string, value ByteView)   { p.c.Add(key, value) }
+func (p *twoQueuePolicy) Get(key string) (ByteView, bool)  { return p.c.Get(key) }
+func (p *twoQueuePolicy) Peek(key string) (ByteView, bool) { return p.c.Peek(key) }
+func (p *twoQueuePolicy) Remove(key string)                { p.c.Remove(key) }
+func (p *twoQueuePolicy) RemoveOldest()                    { p.c.RemoveOldest() }
+func (p *twoQueuePolicy) Len() int                         { return p.c.Len() }
+
+// LRUKPolicy keeps the entries accessed fewer than k times in a history
+// queue that is evicted first, in least recently used order.
+// historyEntries limits the history queue, zero means no limit.
+func LRUKPolicy(k int, historyEntries int) PolicyFunc {
+	return func(onEvicted func(key string, value ByteView)) EvictionPolicy {
+		return &lruKPolicy{lru.NewLRUK(k, 0, historyEntries, func(key lru.Key, value lru.Value) {
+			onEvicted(key.(string), value.(ByteView))
+		})}
+	}
+}
+
+type lruKPolicy struct {
+	c *lru.LRUKCache
+}
+
+func (p *lruKPolicy) Add(key string, value ByteView) { p.c.Add(key, value) }
+func (p *lruKPolicy) Remove(key string)              { p.c.Remove(key) }
+func (p *lruKPolicy) RemoveOldest()                  { p.c.RemoveOldest() }
+func (p *lruKPolicy) Len() int                       { return p.c.Len() }
+
+func (p *lruKPolicy) Get(key string) (ByteView, bool) {
+	value, ok := p.c.Get(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	return value.(ByteView), true
+}
+
+func (p *lruKPolicy) Peek(key string) (ByteView, bool) {
+	value, ok := p.c.Peek(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	return value.(ByteView), true
+}
+
+// GreedyDualSizePolicy weighs the cost of loading an entry again against
+// its size and recency: it evicts first the entries that took the least
+// time to load per byte, among those not used since the last evictions.
+// Large values that are cheap to reload go before small, slow ones.
+// The cost is the latency the group measured for the load, values
+// written with Group.Set or pushed by a replica count as the cheapest.
+func GreedyDualSizePolicy() PolicyFunc {
+	return func(onEvicted func(key string, value ByteView)) EvictionPolicy {
+		return &greedyDualPolicy{greedyDual.New[string, ByteView](onEvicted)}
+	}
+}
+
+type greedyDualPolicy struct {
+	c *greedyDual.Cache[string, ByteView]
+}
+
+func (p *greedyDualPolicy) Add(key string, value ByteView) {
+	p.c.Add(key, value, float64(value.cost), len(key)+value.Len())
+}
+
+func (p *greedyDualPolicy) Get(key string) (ByteView, bool)  { return p.c.Get(key) }
+func (p *greedyDualPolicy) Peek(key string) (ByteView, bool) { return p.c.Peek(key) }
+func (p *greedyDualPolicy) Remove(key string)                { p.c.Remove(key) }
+func (p *greedyDualPolicy) RemoveOldest()                    { p.c.Evict() }
+func (p *greedyDualPolicy) Len() int                         { return p.c.Len() }
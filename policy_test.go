@@ -0,0 +1,94 @@
+package dailzCache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// scanGroup loads 4 hot keys twice, then scans 100 cold keys once, with
+// room for about 8 entries, and returns how many hot keys survived.
+func scanGroup(t *testing.T, name string, policy PolicyFunc) int {
+	var loads int
+	g := NewUniverse().NewGroupOpts(name, 8*int64(len("hot0")+len("value")), GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte("value"), nil
+		}), &GroupOptions{Policy: policy})
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 4; i++ {
+			if _, err := g.Get(fmt.Sprintf("hot%d", i)); err != nil {
+				t.Fatalf("Get error = %v", err)
+			}
+		}
+	}
+	for i := 0; i < 100; i++ {
+		g.Get(fmt.Sprintf("c%03d", i))
+	}
+
+	survived := 0
+	for i := 0; i < 4; i++ {
+		if _, ok := g.mainCache.get(fmt.Sprintf("hot%d", i)); ok {
+			survived++
+		}
+	}
+	return survived
+}
+
+func TestPolicyScanResistance(t *testing.T) {
+	if got := scanGroup(t, "policy-lru", nil); got != 0 {
+		t.Errorf("LRU kept %d hot keys through a scan; want 0", got)
+	}
+	if got := scanGroup(t, "policy-2q", TwoQueuePolicy(0.25, 0)); got != 4 {
+		t.Errorf("2Q kept %d hot keys through a scan; want 4", got)
+	}
+	if got := scanGroup(t, "policy-lru-k", LRUKPolicy(2, 0)); got != 4 {
+		t.Errorf("LRU-K kept %d hot keys through a scan; want 4", got)
+	}
+}
+
+func TestPolicyByteAccounting(t *testing.T) {
+	for name, policy := range map[string]PolicyFunc{
+		"lru":   LRUPolicy(),
+		"2q":    TwoQueuePolicy(0, 0),
+		"lru-k": LRUKPolicy(2, 0),
+		"gds":   GreedyDualSizePolicy(),
+	} {
+		c := cache{newPolicy: policy}
+		c.add("a", ByteView{str: "12"})
+		c.add("a", ByteView{str: "1234"})
+		c.add("bb", ByteView{str: "1"})
+		if got, want := c.bytes(), int64(len("a1234bb1")); got != want {
+			t.Errorf("%s: bytes = %d; want %d", name, got, want)
+		}
+		c.remove("a")
+		c.removeOldest()
+		if got := c.bytes(); got != 0 {
+			t.Errorf("%s: bytes = %d after removing everything; want 0", name, got)
+		}
+	}
+}
+
+func TestGreedyDualSizePolicy(t *testing.T) {
+	g := NewUniverse().NewGroupOpts("policy-greedy-dual", 8*int64(len("c000")+len("value")), GetterFunc(
+		func(key string) ([]byte, error) {
+			if key == "slow" {
+				time.Sleep(10 * time.Millisecond)
+			}
+			return []byte("value"), nil
+		}), &GroupOptions{Policy: GreedyDualSizePolicy()})
+
+	if _, err := g.Get("slow"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		g.Get(fmt.Sprintf("c%03d", i))
+	}
+	if _, ok := g.mainCache.get("slow"); !ok {
+		t.Errorf("slow key was evicted by cheap ones")
+	}
+	if got := g.CacheStats(MainCache).Items; got > 8 {
+		t.Errorf("Items = %d; want at most 8", got)
+	}
+}
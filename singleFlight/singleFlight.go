@@ -2,49 +2,327 @@
 // mechanism.
 package singleFlight
 
-import "sync"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// errGoexit indicates that fn called runtime.Goexit.
+var errGoexit = errors.New("runtime.Goexit was called")
+
+// A panicError is the value recovered from a panic in fn, together
+// with the stack trace of the panic.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (p *panicError) Unwrap() error {
+	err, _ := p.value.(error)
+	return err
+}
+
+func newPanicError(v interface{}) error {
+	stack := debug.Stack()
+	// 第一行是 "goroutine N [status]:"，panic 传给等待者时该 goroutine 的状态已经变了，去掉这一行
+	if line := bytes.IndexByte(stack, '\n'); line >= 0 {
+		stack = stack[line+1:]
+	}
+	return &panicError{value: v, stack: stack}
+}
 
 // call is an in-flight or completed Do call
-type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+type call[V any] struct {
+	done chan struct{} // closed when fn returns
+	val  V
+	err  error
+
+	// cancel cancels the context fn runs with.
+	cancel context.CancelFunc
+
+	// dups counts the callers that waited for this call instead of
+	// making their own, waiters the callers still waiting for it, and
+	// chans holds the channels of DoChan callers.
+	// They are guarded by the Group's mu.
+	dups    int
+	waiters int
+	chans   []chan<- Result[V]
+	// lingering is set while the results are kept after fn returned.
+	lingering bool
 }
 
 // Group represents a class of work and forms a namespace in which
 // units of work can be executed with duplicate suppression.
-type Group struct {
-	mu sync.Mutex
-	m  map[string]*call
+type Group[K comparable, V any] struct {
+	// Linger specifies how long the results of a finished call, errors
+	// included, are handed to new callers of the key instead of calling
+	// fn again, absorbing bursts that arrive just after it finishes.
+	// Results of calls that panicked or called runtime.Goexit don't linger.
+	// If zero, a call is forgotten as soon as it finishes.
+	Linger time.Duration
+
+	mu        sync.Mutex
+	m         map[K]*call[V]
+	lingering int // number of calls of m whose results linger
+}
+
+// Result holds the results of Do, so they can be passed on a channel.
+type Result[V any] struct {
+	Val    V
+	Err    error
+	Shared bool
 }
 
 // Do executes and returns the results of the given function, making
 // sure that only one execution is in-flight for a given key at a
 // time. If a duplicate comes in, the duplicate caller waits for the
 // original to complete and receives the same results.
+// The return value shared reports whether v was given to multiple callers.
+//
+// fn runs with a context that carries the values of ctx of the caller
+// that started it, and is cancelled only once every caller has gone
+// away. A caller whose ctx is done returns ctx.Err() at once, while the
+// others keep waiting for fn.
+//
+// If fn panics or calls runtime.Goexit, so does every caller waiting for it.
+// A panic with no caller left to receive it crashes the program.
 // 针对相同的 key，无论 Do 被调用多少次，函数 fn 都只被调用一次，等待 fn 调用结束后，返回返回值或错误
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+func (g *Group[K, V]) Do(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (v V, err error, shared bool) {
 	g.mu.Lock()
-	if g.m == nil {
-		g.m = make(map[string]*call)
+	c, finished := g.join(ctx, key, fn)
+	g.mu.Unlock()
+	if finished {
+		return c.val, c.err, true
 	}
 
-	if c, ok := g.m[key]; ok {
+	select {
+	case <-c.done: // 请求结束
+	case <-ctx.Done():
+		// 调用方放弃等待，最后一个离开的调用方取消 fn
+		g.mu.Lock()
+		shared = c.dups > 0
+		select {
+		case <-c.done:
+		default:
+			g.leave(key, c)
+			g.mu.Unlock()
+			return v, ctx.Err(), shared
+		}
 		g.mu.Unlock()
-		c.wg.Wait()         // 如果请求正在进行中，等待
-		return c.val, c.err // 请求结束，返回结果
 	}
 
-	c := new(call)
-	c.wg.Add(1)  // 发起请求前加锁
-	g.m[key] = c // 添加到 g.m，表明 key 已经有对应的请求在处理
+	if e, ok := c.err.(*panicError); ok {
+		panic(e)
+	} else if c.err == errGoexit {
+		runtime.Goexit()
+	}
+	return c.val, c.err, c.dups > 0 // 返回结果
+}
+
+// DoChan is like Do but returns a channel that will receive the
+// results when they are ready, so that the caller can select on it.
+// The channel is never closed, and receives nothing once ctx is done:
+// the caller stops waiting for fn as with Do.
+// If fn panics, the panic is not recovered and crashes the program.
+func (g *Group[K, V]) DoChan(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) <-chan Result[V] {
+	ch, _ := g.DoChanLead(ctx, key, fn)
+	return ch
+}
+
+// DoChanLead is like DoChan, and also reports whether fn is called for
+// this call, rather than the call joining one in flight or lingering.
+func (g *Group[K, V]) DoChanLead(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (<-chan Result[V], bool) {
+	ch := make(chan Result[V], 1)
+	g.mu.Lock()
+	c, finished := g.join(ctx, key, fn)
+	if finished {
+		g.mu.Unlock()
+		ch <- Result[V]{c.val, c.err, true}
+		return ch, false
+	}
+	lead := c.dups == 0
+	c.chans = append(c.chans, ch)
 	g.mu.Unlock()
 
-	c.val, c.err = fn() // 调用 fn，发起请求
-	c.wg.Done()         //请求结束
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-c.done:
+			case <-ctx.Done():
+				g.mu.Lock()
+				select {
+				case <-c.done:
+				default:
+					c.removeChan(ch)
+					g.leave(key, c)
+				}
+				g.mu.Unlock()
+			}
+		}()
+	}
+	return ch, lead
+}
 
+// join adds a waiter to the call in flight for key, starting one with
+// fn if there is none. If the call for key has finished and its results
+// linger, it is returned with finished set instead. g.mu must be held.
+func (g *Group[K, V]) join(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (c *call[V], finished bool) {
+	if g.m == nil {
+		g.m = make(map[K]*call[V])
+	}
+	if c, ok := g.m[key]; ok {
+		select {
+		case <-c.done:
+			return c, true
+		default:
+		}
+		c.dups++
+		c.waiters++
+		return c, false
+	}
+
+	// fn 不随发起请求的调用方一起取消
+	callCtx, cancel := context.WithCancel(valuesOnly{ctx})
+	c = &call[V]{done: make(chan struct{}), cancel: cancel, waiters: 1}
+	g.m[key] = c // 添加到 g.m，表明 key 已经有对应的请求在处理
+	go g.doCall(callCtx, c, key, fn)
+	return c, false
+}
+
+// leave removes a waiter from c whose context is done. Once no one is
+// waiting any more, c is cancelled and forgotten. g.mu must be held.
+func (g *Group[K, V]) leave(key K, c *call[V]) {
+	c.waiters--
+	if c.waiters > 0 {
+		return
+	}
+	c.cancel()
+	if g.m[key] == c {
+		delete(g.m, key)
+	}
+}
+
+// removeChan removes ch from the channels waiting for c.
+// The Group's mu must be held.
+func (c *call[V]) removeChan(ch chan<- Result[V]) {
+	for i, x := range c.chans {
+		if x == ch {
+			c.chans = append(c.chans[:i], c.chans[i+1:]...)
+			return
+		}
+	}
+}
+
+// doCall handles the single call for a key.
+func (g *Group[K, V]) doCall(ctx context.Context, c *call[V], key K, fn func(ctx context.Context) (V, error)) {
+	normalReturn := false
+	recovered := false
+
+	// 用两层 defer 区分 fn 的 panic 和 runtime.Goexit：
+	// Goexit 不能被 recover，内层 defer 执行时 recover() 返回 nil
+	defer func() {
+		if !normalReturn && !recovered {
+			c.err = errGoexit
+		}
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		c.cancel()
+		if g.m[key] == c {
+			// 更新 g.m，key 可能已经被 Forget。
+			// 设置了 Linger 时结果保留一段时间，紧随其后的请求直接使用该结果
+			_, panicked := c.err.(*panicError)
+			if g.Linger > 0 && !panicked && c.err != errGoexit {
+				c.lingering = true
+				g.lingering++
+				time.AfterFunc(g.Linger, func() {
+					g.mu.Lock()
+					defer g.mu.Unlock()
+					if g.m[key] == c {
+						g.forget(key)
+					}
+				})
+			} else {
+				delete(g.m, key)
+			}
+		}
+		close(c.done)
+
+		if e, ok := c.err.(*panicError); ok {
+			if len(c.chans) > 0 || c.waiters == 0 {
+				// DoChan 的调用方无法 recover，没有调用方在等待时 panic 也不能丢失，
+				// 在新的 goroutine 中 panic 让程序崩溃，当前 goroutine 保持阻塞以便出现在崩溃信息中
+				go panic(e)
+				select {}
+			}
+			// 由等待的 Do 调用方各自 panic
+		} else if c.err == errGoexit {
+			// 由等待的 Do 调用方各自调用 Goexit
+		} else {
+			for _, ch := range c.chans {
+				ch <- Result[V]{c.val, c.err, c.dups > 0}
+			}
+		}
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				if r := recover(); r != nil {
+					c.err = newPanicError(r)
+				}
+			}
+		}()
+
+		c.val, c.err = fn(ctx) // 调用 fn，发起请求
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
+}
+
+// Forget tells the group to forget about a key. Future calls to Do
+// for this key will call the function rather than waiting for an
+// earlier call to complete, or reusing its lingering results.
+func (g *Group[K, V]) Forget(key K) {
 	g.mu.Lock()
-	delete(g.m, key) //更新 g.m
+	g.forget(key)
 	g.mu.Unlock()
-	return c.val, c.err //返回结果
 }
+
+// forget removes key from g.m. g.mu must be held.
+func (g *Group[K, V]) forget(key K) {
+	if c, ok := g.m[key]; ok && c.lingering {
+		g.lingering--
+	}
+	delete(g.m, key)
+}
+
+// Lingering returns the number of finished calls whose results linger,
+// see Linger. They are held on top of whatever the caller keeps.
+func (g *Group[K, V]) Lingering() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.lingering
+}
+
+// valuesOnly is a context with the values of Context
+// but never cancelled and without a deadline.
+type valuesOnly struct{ context.Context }
+
+func (valuesOnly) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valuesOnly) Done() <-chan struct{}       { return nil }
+func (valuesOnly) Err() error                  { return nil }
@@ -1,8 +1,13 @@
 package singleFlight
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -10,8 +15,8 @@ import (
 )
 
 func TestDo(t *testing.T) {
-	var g Group
-	v, err := g.Do("key", func() (interface{}, error) {
+	var g Group[string, string]
+	v, err, _ := g.Do(context.Background(), "key", func(context.Context) (string, error) {
 		return "bar", nil
 	})
 
@@ -24,24 +29,24 @@ func TestDo(t *testing.T) {
 }
 
 func TestDoErr(t *testing.T) {
-	var g Group
+	var g Group[string, string]
 	someErr := errors.New("some error")
-	v, err := g.Do("key", func() (interface{}, error) {
-		return nil, someErr
+	v, err, _ := g.Do(context.Background(), "key", func(context.Context) (string, error) {
+		return "", someErr
 	})
 	if err != someErr {
 		t.Errorf("Do error = %v; want = %v", err, someErr)
 	}
-	if v != nil {
-		t.Errorf("unexpected non-nil value %v", v)
+	if v != "" {
+		t.Errorf("unexpected non-zero value %q", v)
 	}
 }
 
 func TestDoDupSuppress(t *testing.T) {
-	var g Group
+	var g Group[string, string]
 	c := make(chan string)
 	var calls int32
-	fn := func() (interface{}, error) {
+	fn := func(context.Context) (string, error) {
 		atomic.AddInt32(&calls, 1)
 		return <-c, nil
 	}
@@ -51,11 +56,11 @@ func TestDoDupSuppress(t *testing.T) {
 	for i := 0; i < n; i++ {
 		wg.Add(1)
 		go func() {
-			v, err := g.Do("key", fn)
+			v, err, _ := g.Do(context.Background(), "key", fn)
 			if err != nil {
 				t.Errorf("Do error: %v", err)
 			}
-			if v.(string) != "bar" {
+			if v != "bar" {
 				t.Errorf("got %v; want %v", v, "bar")
 			}
 			wg.Done()
@@ -69,3 +74,495 @@ func TestDoDupSuppress(t *testing.T) {
 		t.Errorf("number of calls = %v; want 1", got)
 	}
 }
+
+func TestDoShared(t *testing.T) {
+	var g Group[string, string]
+	_, _, shared := g.Do(context.Background(), "key", func(context.Context) (string, error) {
+		return "bar", nil
+	})
+	if shared {
+		t.Errorf("a call with no duplicates reported shared")
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	first := make(chan bool)
+	go func() {
+		_, _, shared := g.Do(context.Background(), "key", func(context.Context) (string, error) {
+			close(started)
+			<-release
+			return "bar", nil
+		})
+		first <- shared
+	}()
+	<-started
+	second := make(chan bool)
+	go func() {
+		_, _, shared := g.Do(context.Background(), "key", func(context.Context) (string, error) {
+			t.Errorf("duplicate call of fn")
+			return "", nil
+		})
+		second <- shared
+	}()
+	// 等待第二个调用进入等待
+	for {
+		g.mu.Lock()
+		dups := g.m["key"].dups
+		g.mu.Unlock()
+		if dups == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if !<-first || !<-second {
+		t.Errorf("callers sharing a result did not both report shared")
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group[string, string]
+	ch := g.DoChan(context.Background(), "key", func(context.Context) (string, error) {
+		return "bar", nil
+	})
+
+	res := <-ch
+	if got, want := fmt.Sprintf("%v (%T)", res.Val, res.Val), "bar (string)"; got != want {
+		t.Errorf("DoChan = %v; want %v", got, want)
+	}
+	if res.Err != nil || res.Shared {
+		t.Errorf("DoChan error = %v, shared = %v; want nil, false", res.Err, res.Shared)
+	}
+}
+
+func TestDoChanDupSuppress(t *testing.T) {
+	var g Group[string, string]
+	release := make(chan struct{})
+	var calls int32
+	fn := func(context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+	ch1 := g.DoChan(context.Background(), "key", fn)
+	ch2 := g.DoChan(context.Background(), "key", fn)
+	close(release)
+
+	for _, ch := range []<-chan Result[string]{ch1, ch2} {
+		select {
+		case res := <-ch:
+			if res.Val != "bar" || !res.Shared {
+				t.Errorf("DoChan = %+v; want a shared bar", res)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("DoChan result never arrived")
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("number of calls = %v; want 1", got)
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group[string, int]
+	started := make(chan struct{})
+	release := make(chan struct{})
+	ch1 := g.DoChan(context.Background(), "key", func(context.Context) (int, error) {
+		close(started)
+		<-release
+		return 1, nil
+	})
+	<-started
+
+	g.Forget("key")
+	// key 被 Forget 之后，新的调用不再等待正在进行的调用
+	v, _, shared := g.Do(context.Background(), "key", func(context.Context) (int, error) {
+		return 2, nil
+	})
+	if v != 2 || shared {
+		t.Errorf("Do after Forget = %v, shared %v; want 2, false", v, shared)
+	}
+
+	close(release)
+	if res := <-ch1; res.Val != 1 {
+		t.Errorf("forgotten call = %v; want 1", res.Val)
+	}
+	// the forgotten call must not remove the newer call for the key
+	ch3 := g.DoChan(context.Background(), "key", func(context.Context) (int, error) {
+		return 3, nil
+	})
+	if res := <-ch3; res.Val != 3 {
+		t.Errorf("DoChan after Forget = %v; want 3", res.Val)
+	}
+}
+
+func TestPanicDo(t *testing.T) {
+	var g Group[string, string]
+	release := make(chan struct{})
+	fn := func(context.Context) (string, error) {
+		<-release
+		panic("invalid memory address or nil pointer dereference")
+	}
+
+	const n = 5
+	var waiting, panics int32
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer func() {
+				if err := recover(); err != nil {
+					if _, ok := err.(*panicError); !ok {
+						t.Errorf("recovered %T; want a *panicError", err)
+					}
+					atomic.AddInt32(&panics, 1)
+				}
+				wg.Done()
+			}()
+			atomic.AddInt32(&waiting, 1)
+			g.Do(context.Background(), "key", fn)
+		}()
+	}
+	for atomic.LoadInt32(&waiting) < n {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Do hangs after fn panicked")
+	}
+	if panics != n {
+		t.Errorf("%d of %d callers panicked", panics, n)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.m["key"]; ok {
+		t.Errorf("key still in flight after fn panicked")
+	}
+}
+
+func TestGoexitDo(t *testing.T) {
+	var g Group[string, string]
+	release := make(chan struct{})
+	fn := func(context.Context) (string, error) {
+		<-release
+		runtime.Goexit()
+		return "", nil
+	}
+
+	const n = 5
+	var waiting, returned int32
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			atomic.AddInt32(&waiting, 1)
+			g.Do(context.Background(), "key", fn)
+			atomic.AddInt32(&returned, 1)
+		}()
+	}
+	for atomic.LoadInt32(&waiting) < n {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Do hangs after fn called runtime.Goexit")
+	}
+	if returned != 0 {
+		t.Errorf("%d callers returned from Do; want all to exit", returned)
+	}
+}
+
+func TestPanicErrorUnwrap(t *testing.T) {
+	someErr := errors.New("some error")
+	var g Group[string, string]
+	func() {
+		defer func() {
+			err, _ := recover().(error)
+			if !errors.Is(err, someErr) {
+				t.Errorf("recovered %v; want it to wrap %v", err, someErr)
+			}
+		}()
+		g.Do(context.Background(), "key", func(context.Context) (string, error) {
+			panic(someErr)
+		})
+	}()
+}
+
+func TestPanicDoChan(t *testing.T) {
+	if os.Getenv("TEST_PANIC_DOCHAN") != "" {
+		defer func() {
+			recover()
+		}()
+
+		g := new(Group[string, string])
+		ch := g.DoChan(context.Background(), "", func(context.Context) (string, error) {
+			panic("Panicking in DoChan")
+		})
+		<-ch
+		t.Fatalf("DoChan unexpectedly returned")
+	}
+
+	// DoChan 中的 panic 无法 recover，在子进程中运行并检查它崩溃了
+	cmd := exec.Command(os.Args[0], "-test.run="+t.Name(), "-test.v")
+	cmd.Env = append(os.Environ(), "TEST_PANIC_DOCHAN=1")
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := cmd.Wait()
+	if err == nil {
+		t.Errorf("Test subprocess passed; want a crash due to panic in DoChan")
+	}
+	if bytes.Contains(out.Bytes(), []byte("DoChan unexpectedly")) {
+		t.Errorf("Test subprocess failed with an unexpected failure mode")
+	}
+	if !bytes.Contains(out.Bytes(), []byte("Panicking in DoChan")) {
+		t.Errorf("Test subprocess failed, but the crash isn't caused by panicking in DoChan:\n%s", out.Bytes())
+	}
+}
+
+func TestDoCallerCancel(t *testing.T) {
+	var g Group[string, string]
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fnErr := make(chan error, 1)
+	fn := func(ctx context.Context) (string, error) {
+		close(started)
+		<-release
+		fnErr <- ctx.Err()
+		return "bar", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err, _ := g.Do(ctx, "key", fn)
+		first <- err
+	}()
+	<-started
+	second := make(chan string)
+	go func() {
+		v, _, _ := g.Do(context.Background(), "key", fn)
+		second <- v
+	}()
+	for {
+		g.mu.Lock()
+		dups := g.m["key"].dups
+		g.mu.Unlock()
+		if dups == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 第一个调用方离开，fn 继续为第二个调用方运行
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("cancelled caller error = %v; want %v", err, context.Canceled)
+	}
+	close(release)
+	if v := <-second; v != "bar" {
+		t.Errorf("remaining caller got %q; want %q", v, "bar")
+	}
+	if err := <-fnErr; err != nil {
+		t.Errorf("fn context error = %v; want nil while a caller waits", err)
+	}
+}
+
+func TestDoAllCallersGone(t *testing.T) {
+	var g Group[string, string]
+	var calls int32
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			return "bar", nil
+		}
+		<-ctx.Done()
+		close(cancelled)
+		return "", ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err, _ := g.Do(ctx, "key", fn); err != context.DeadlineExceeded {
+		t.Errorf("Do error = %v; want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("fn not cancelled after every caller went away")
+	}
+
+	// the abandoned call is not joined by later callers
+	if v, err, _ := g.Do(context.Background(), "key", fn); v != "bar" || err != nil {
+		t.Errorf("Do = %q, %v; want %q", v, err, "bar")
+	}
+}
+
+func TestDoContextValues(t *testing.T) {
+	type ctxKey struct{}
+	var g Group[string, string]
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), ctxKey{}, "bar"), time.Hour)
+	defer cancel()
+	v, _, _ := g.Do(ctx, "key", func(ctx context.Context) (string, error) {
+		if _, ok := ctx.Deadline(); ok {
+			t.Errorf("fn context has the deadline of the caller")
+		}
+		s, _ := ctx.Value(ctxKey{}).(string)
+		return s, nil
+	})
+	if v != "bar" {
+		t.Errorf("fn saw value %q; want %q from the caller's context", v, "bar")
+	}
+}
+
+func TestLinger(t *testing.T) {
+	g := Group[string, string]{Linger: 50 * time.Millisecond}
+	someErr := errors.New("origin down")
+	var calls int32
+	fn := func(context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "", someErr
+	}
+
+	if _, err, shared := g.Do(context.Background(), "key", fn); err != someErr || shared {
+		t.Fatalf("Do = %v, shared %v; want %v, false", err, shared, someErr)
+	}
+	// 紧随其后的请求直接得到保留的错误
+	if _, err, shared := g.Do(context.Background(), "key", fn); err != someErr || !shared {
+		t.Errorf("Do while lingering = %v, shared %v; want %v, true", err, shared, someErr)
+	}
+	if res := <-g.DoChan(context.Background(), "key", fn); res.Err != someErr || !res.Shared {
+		t.Errorf("DoChan while lingering = %+v; want %v, shared", res, someErr)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("number of calls while lingering = %v; want 1", got)
+	}
+	if got := g.Lingering(); got != 1 {
+		t.Errorf("Lingering = %d; want 1", got)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := g.Lingering(); got != 0 {
+		t.Errorf("Lingering after Linger = %d; want 0", got)
+	}
+	g.Do(context.Background(), "key", fn)
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("number of calls after lingering = %v; want 2", got)
+	}
+
+	g.Forget("key")
+	if got := g.Lingering(); got != 0 {
+		t.Errorf("Lingering after Forget = %d; want 0", got)
+	}
+	g.Do(context.Background(), "key", fn)
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("number of calls after Forget = %v; want 3", got)
+	}
+}
+
+func TestLingerNotAfterPanic(t *testing.T) {
+	g := Group[string, string]{Linger: time.Hour}
+	var calls int32
+	fn := func(context.Context) (string, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("first call panics")
+		}
+		return "bar", nil
+	}
+
+	func() {
+		defer func() { recover() }()
+		g.Do(context.Background(), "key", fn)
+	}()
+	if v, _, _ := g.Do(context.Background(), "key", fn); v != "bar" {
+		t.Errorf("Do after a panic = %q; want %q from a new call", v, "bar")
+	}
+}
+
+func TestDoChanCallerCancel(t *testing.T) {
+	var g Group[string, string]
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		<-release
+		return "bar", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := g.DoChan(ctx, "key", fn)
+	other := g.DoChan(context.Background(), "key", fn)
+	cancel()
+	for {
+		g.mu.Lock()
+		waiters, chans := g.m["key"].waiters, len(g.m["key"].chans)
+		g.mu.Unlock()
+		if waiters == 1 {
+			if chans != 1 {
+				t.Errorf("call holds %d channels after a DoChan caller left; want 1", chans)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	if r := <-other; r.Val != "bar" {
+		t.Errorf("remaining caller got %q; want %q", r.Val, "bar")
+	}
+	select {
+	case r := <-ch:
+		t.Errorf("cancelled caller received %v", r)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestDoChanLead(t *testing.T) {
+	var g Group[string, string]
+	release := make(chan struct{})
+	var calls int32
+	fn := func(ctx context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+
+	first, lead := g.DoChanLead(context.Background(), "key", fn)
+	if !lead {
+		t.Errorf("first DoChanLead lead = false; want true")
+	}
+	second, lead := g.DoChanLead(context.Background(), "key", fn)
+	if lead {
+		t.Errorf("DoChanLead joining a call in flight lead = true; want false")
+	}
+	close(release)
+	for _, ch := range []<-chan Result[string]{first, second} {
+		if r := <-ch; r.Val != "bar" || !r.Shared {
+			t.Errorf("DoChanLead = %+v; want bar, shared", r)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("number of calls = %d; want 1", got)
+	}
+}
@@ -0,0 +1,211 @@
+package dailzCache
+
+import (
+	"context"
+	"github.com/dailz1/dailzCache/consistentHash"
+	"github.com/dailz1/dailzCache/singleFlight"
+	"sync"
+)
+
+// A Universe is an isolated cache instance. It owns a set of groups,
+// the peer picker they use and at most one HTTPPool, so several
+// clusters, or several nodes of one cluster, can run in one process.
+// The package-level functions use a default Universe.
+type Universe struct {
+	mu           sync.RWMutex // guards groups, portPicker and httpPoolMade
+	groups       map[string]*Group
+	portPicker   func(groupName string) PeerPicker
+	httpPoolMade bool
+}
+
+// NewUniverse creates an empty Universe.
+func NewUniverse() *Universe {
+	return &Universe{
+		groups: make(map[string]*Group),
+	}
+}
+
+var defaultUniverse = NewUniverse()
+
+// NewGroup creates a new group in the universe,
+// the name must be unique for each getter in the universe.
+func (u *Universe) NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+	return u.newGroup(name, cacheBytes, getter, nil, nil)
+}
+
+// NewGroupOpts creates a new group in the universe with the given options.
+func (u *Universe) NewGroupOpts(name string, cacheBytes int64, getter Getter, opts *GroupOptions) *Group {
+	return u.newGroup(name, cacheBytes, getter, nil, opts)
+}
+
+func (u *Universe) newGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker, opts *GroupOptions) *Group {
+	g := u.makeGroup(name, cacheBytes, getter, peers, opts)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.groups[name]; ok {
+		panic("duplicate registration of group " + name)
+	}
+	u.groups[name] = g
+	return g
+}
+
+// ReplaceGroup creates a group like NewGroupOpts and registers it in
+// place of the group with the same name, which is then closed.
+// Lookups of the name find one group or the other, never none, but a
+// caller still using the old group gets ErrGroupClosed; the HTTPPool
+// answers such requests with 503 Service Unavailable, for peers to retry.
+// Peers registered with Group.RegisterPeers are not carried over.
+func (u *Universe) ReplaceGroup(name string, cacheBytes int64, getter Getter, opts *GroupOptions) *Group {
+	g := u.makeGroup(name, cacheBytes, getter, nil, opts)
+
+	u.mu.Lock()
+	old := u.groups[name]
+	u.groups[name] = g
+	u.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return g
+}
+
+// UnregisterGroup removes the named group from the universe and closes it.
+// It reports whether there was such a group.
+func (u *Universe) UnregisterGroup(name string) bool {
+	g := u.GetGroup(name)
+	if g == nil {
+		return false
+	}
+	g.Close()
+	return true
+}
+
+// unregister removes g from the universe, unless it has been replaced.
+func (u *Universe) unregister(g *Group) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.groups[g.name] == g {
+		delete(u.groups, g.name)
+	}
+}
+
+// makeGroup creates a group of the universe without registering it.
+func (u *Universe) makeGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker, opts *GroupOptions) *Group {
+	if getter == nil {
+		panic("nil Getter")
+	}
+
+	g := &Group{
+		name:       name,
+		getter:     getter,
+		cacheBytes: cacheBytes,
+		peers:      peers,
+		universe:   u,
+	}
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	if opts != nil {
+		g.opts = *opts
+	}
+	if g.opts.TTL > 0 && g.opts.LoadLinger > g.opts.TTL {
+		g.opts.LoadLinger = g.opts.TTL
+	}
+	g.loadGroup = &singleFlight.Group[string, ByteView]{Linger: g.opts.LoadLinger}
+	g.fills = make(chan struct{}, maxReplicaFills)
+	if g.opts.ReclaimInterval <= 0 {
+		g.opts.ReclaimInterval = defaultReclaimInterval
+	}
+	g.mainCache.init(g.opts.CacheShards, g.opts.StaleWhileRevalidate, g.opts.Policy)
+	g.hotCache.init(g.opts.CacheShards, g.opts.StaleWhileRevalidate, g.opts.Policy)
+	if g.opts.HotAdmission == nil {
+		g.opts.HotAdmission = NewFrequencyAdmission(0, 0)
+	}
+	return g
+}
+
+// GetGroup returns the named group of the universe, or nil if there is none.
+func (u *Universe) GetGroup(name string) *Group {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.groups[name]
+}
+
+// RegisterPeerPicker registers the peer initialization function of the universe.
+// It is called once for each group, when the group first needs its peers.
+// Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
+// called exactly once, but not both.
+func (u *Universe) RegisterPeerPicker(fn func() PeerPicker) {
+	u.RegisterPerGroupPeerPicker(func(_ string) PeerPicker {
+		return fn()
+	})
+}
+
+// RegisterPerGroupPeerPicker registers the peer initialization function,
+// which takes the groupName, to be used in choosing a PeerPicker.
+// Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
+// called exactly once, but not both.
+func (u *Universe) RegisterPerGroupPeerPicker(fn func(groupName string) PeerPicker) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.portPicker != nil {
+		panic("RegisterPeerPicker called more than once")
+	}
+	u.portPicker = fn
+}
+
+// GetPeers returns the PeerPicker of the named group, NoPeers if
+// no peer picker is registered.
+func (u *Universe) GetPeers(groupName string) PeerPicker {
+	u.mu.RLock()
+	portPicker := u.portPicker
+	u.mu.RUnlock()
+	if portPicker == nil {
+		return NoPeers{}
+	}
+	pk := portPicker(groupName)
+	if pk == nil {
+		pk = NoPeers{}
+	}
+	return pk
+}
+
+// NewHTTPPoolOpts initializes the HTTP pool of the universe with the given
+// options and registers it as the universe's PeerPicker.
+// It must be called at most once per universe.
+// The returned *HTTPPool implements Http.Handler and must be registered using Http.Handle.
+func (u *Universe) NewHTTPPoolOpts(self string, opts *HTTPPoolOptions) *HTTPPool {
+	u.mu.Lock()
+	if u.httpPoolMade {
+		u.mu.Unlock()
+		panic("daiCache: NewHTTPPool must be called only once")
+	}
+	u.httpPoolMade = true
+	u.mu.Unlock()
+
+	p := &HTTPPool{
+		self:        self,
+		universe:    u,
+		httpGetters: make(map[string]*httpGetter),
+	}
+
+	if opts != nil {
+		p.opts = *opts
+	}
+	if p.opts.BasePath == "" {
+		p.opts.BasePath = defaultBasePath
+	}
+	if p.opts.MaxRequestBytes <= 0 {
+		p.opts.MaxRequestBytes = defaultMaxRequestBytes
+	}
+	if p.opts.Replicas == 0 {
+		p.opts.Replicas = defaultReplicas
+	}
+	if p.opts.ReplicationFactor <= 0 {
+		p.opts.ReplicationFactor = 1
+	}
+	p.peers = consistentHash.New(p.opts.Replicas, p.opts.HashFn)
+
+	u.RegisterPeerPicker(func() PeerPicker { return p })
+	return p
+}
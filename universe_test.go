@@ -0,0 +1,203 @@
+package dailzCache
+
+import (
+	"fmt"
+	"github.com/dailz1/dailzCache/consistentHash"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUniversesAreIsolated(t *testing.T) {
+	a, b := NewUniverse(), NewUniverse()
+	a.NewGroup("scores", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("a"), nil
+	}))
+	b.NewGroup("scores", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("b"), nil
+	}))
+	a.NewHTTPPoolOpts("", nil)
+	b.NewHTTPPoolOpts("", nil)
+
+	for u, want := range map[*Universe]string{a: "a", b: "b"} {
+		view, err := u.GetGroup("scores").Get("key")
+		if err != nil {
+			t.Fatalf("Get error = %v", err)
+		}
+		if view.String() != want {
+			t.Errorf("Get = %q; want %q", view.String(), want)
+		}
+	}
+	if GetGroup("scores") != nil {
+		t.Errorf("group of a universe registered in the default universe")
+	}
+}
+
+// TestUniverseCluster runs a cluster of three nodes in one process.
+func TestUniverseCluster(t *testing.T) {
+	const n = 3
+	var (
+		servers [n]*httptest.Server
+		addrs   [n]string
+		groups  [n]*Group
+	)
+	for i := range servers {
+		servers[i] = httptest.NewUnstartedServer(nil)
+		addrs[i] = "http://" + servers[i].Listener.Addr().String()
+	}
+	for i := range servers {
+		i := i
+		u := NewUniverse()
+		groups[i] = u.NewGroup("scores", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+			return []byte(fmt.Sprint(i)), nil
+		}))
+		p := u.NewHTTPPoolOpts(addrs[i], nil)
+		p.Set(addrs[:]...)
+		servers[i].Config.Handler = p
+		servers[i].Start()
+		defer servers[i].Close()
+	}
+
+	for k := 0; k < 10; k++ {
+		key := fmt.Sprint("key", k)
+		var owner string
+		for i, g := range groups {
+			view, err := g.Get(key)
+			if err != nil {
+				t.Fatalf("node %d: Get(%q) error = %v", i, key, err)
+			}
+			if i == 0 {
+				owner = view.String()
+			} else if view.String() != owner {
+				t.Errorf("node %d: Get(%q) = %q; want %q from the owner", i, key, view.String(), owner)
+			}
+		}
+	}
+}
+
+func TestGroupClose(t *testing.T) {
+	u := NewUniverse()
+	g := u.NewGroupOpts("scores", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), &GroupOptions{TTL: time.Hour, ReclaimInterval: time.Millisecond})
+	if _, err := g.Get("key"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+
+	if !u.UnregisterGroup("scores") {
+		t.Fatalf("UnregisterGroup = false; want true")
+	}
+	if u.GetGroup("scores") != nil {
+		t.Errorf("closed group is still registered")
+	}
+	if _, err := g.Get("key"); err != ErrGroupClosed {
+		t.Errorf("Get error = %v; want ErrGroupClosed", err)
+	}
+	if err := g.Set("key", []byte("value")); err != ErrGroupClosed {
+		t.Errorf("Set error = %v; want ErrGroupClosed", err)
+	}
+	g.populateCache("other", ByteView{str: "value"}, &g.mainCache)
+	if got := g.CacheStats(MainCache); got.Bytes != 0 || got.Items != 0 {
+		t.Errorf("MainCache stats = %+v after Close; want empty", got)
+	}
+	g.Close()
+	if u.UnregisterGroup("scores") {
+		t.Errorf("UnregisterGroup of a removed group = true; want false")
+	}
+
+	// the name is free again
+	u.NewGroup("scores", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+}
+
+func TestReplaceGroup(t *testing.T) {
+	u := NewUniverse()
+	old := u.NewGroup("scores", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("old"), nil
+	}))
+	g := u.ReplaceGroup("scores", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("new"), nil
+	}), nil)
+
+	if u.GetGroup("scores") != g {
+		t.Fatalf("GetGroup did not return the new group")
+	}
+	if _, err := old.Get("key"); err != ErrGroupClosed {
+		t.Errorf("old Get error = %v; want ErrGroupClosed", err)
+	}
+	// closing the replaced group again must not unregister the new one
+	old.Close()
+	view, err := u.GetGroup("scores").Get("key")
+	if err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	if view.String() != "new" {
+		t.Errorf("Get = %q; want %q", view.String(), "new")
+	}
+}
+
+// TestUniverseClusterReplicas reads a key from its second replica once
+// its owner is down, without reaching the origin again.
+func TestUniverseClusterReplicas(t *testing.T) {
+	const n = 3
+	var (
+		servers [n]*httptest.Server
+		addrs   [n]string
+		groups  [n]*Group
+		loads   AtomicInt
+	)
+	for i := range servers {
+		servers[i] = httptest.NewUnstartedServer(nil)
+		addrs[i] = "http://" + servers[i].Listener.Addr().String()
+	}
+	for i := range servers {
+		u := NewUniverse()
+		groups[i] = u.NewGroupOpts("scores", 1<<10, countingGetter(&loads),
+			&GroupOptions{ReplicaFills: true})
+		p := u.NewHTTPPoolOpts(addrs[i], &HTTPPoolOptions{ReplicationFactor: 2})
+		p.Set(addrs[:]...)
+		servers[i].Config.Handler = p
+		servers[i].Start()
+		defer servers[i].Close()
+	}
+
+	index := make(map[string]int)
+	for i, addr := range addrs {
+		index[addr] = i
+	}
+	ring := consistentHash.New(defaultReplicas, nil)
+	ring.Add(addrs[:]...)
+	owners := ring.GetN("key", 2)
+	owner, replica := index[owners[0]], index[owners[1]]
+	reader := 3 - owner - replica
+
+	if _, err := groups[reader].Get("key"); err != nil {
+		t.Fatalf("Get error = %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := groups[replica].mainCache.get("key"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("owner did not fill its replica")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	servers[owner].Close()
+	view, err := groups[reader].Get("key")
+	if err != nil {
+		t.Fatalf("Get with the owner down error = %v", err)
+	}
+	if view.String() != "local-key" {
+		t.Errorf("Get = %q; want %q", view.String(), "local-key")
+	}
+	if got := loads.Get(); got != 1 {
+		t.Errorf("origin loads = %d; want 1", got)
+	}
+	if got := groups[reader].Stats.FallbackPeers.Get(); got != 1 {
+		t.Errorf("FallbackPeers = %d; want 1", got)
+	}
+}